@@ -0,0 +1,100 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// screenshot types used for baselines so that screenshots are matched by line number and screenshot name
+const (
+	BaselineFileIndexType    = "line_number"
+	BaselineFileNameBodyType = "screenshot_name"
+)
+
+// baselineManifestName is the file in each baseline version which lists its screenshots
+const baselineManifestName = "manifest.json"
+
+var baselineVersionPattern = regexp.MustCompile(`^v(\d+)$`)
+
+// BaselineVersions returns versions (v1, v2, ...) approved in baselineDir in ascending order
+func BaselineVersions(baselineDir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(baselineDir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, &LocalIOError{Path: baselineDir, Err: err}
+	}
+	numbers := []int{}
+	for _, entry := range entries {
+		if match := baselineVersionPattern.FindStringSubmatch(entry.Name()); match != nil && entry.IsDir() {
+			number, _ := strconv.Atoi(match[1])
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	versions := make([]string, 0, len(numbers))
+	for _, number := range numbers {
+		versions = append(versions, fmt.Sprintf("v%d", number))
+	}
+	return versions, nil
+}
+
+// ApproveBaseline moves screenshots extracted into srcDir into a new version of baselineDir with their manifest,
+// and returns the version
+func ApproveBaseline(baselineDir string, srcDir string, manifest *ScreenshotManifest) (string, error) {
+	if err := SaveScreenshotManifest(manifest, filepath.Join(srcDir, baselineManifestName)); err != nil {
+		return "", err
+	}
+	versions, err := BaselineVersions(baselineDir)
+	if err != nil {
+		return "", err
+	}
+	next := 1
+	if len(versions) > 0 {
+		last, _ := strconv.Atoi(versions[len(versions)-1][1:])
+		next = last + 1
+	}
+	version := fmt.Sprintf("v%d", next)
+	versionDir := filepath.Join(baselineDir, version)
+	// srcDir is usually created by ioutil.TempDir with 0700, while the version is read by others like baselineDir
+	if err := os.Chmod(srcDir, 0755); err != nil {
+		return "", &LocalIOError{Path: srcDir, Err: err}
+	}
+	if err := os.Rename(srcDir, versionDir); err != nil {
+		return "", &LocalIOError{Path: versionDir, Err: err}
+	}
+	return version, nil
+}
+
+// LoadBaseline reads the manifest of the version in baselineDir. The latest version is used if version is empty.
+// It returns the directory of the version together
+func LoadBaseline(baselineDir string, version string) (manifest *ScreenshotManifest, versionDir string, err error) {
+	if version == "" {
+		versions, err := BaselineVersions(baselineDir)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(versions) == 0 {
+			return nil, "", &LocalIOError{Path: baselineDir, Err: fmt.Errorf("no baseline is approved")}
+		}
+		version = versions[len(versions)-1]
+	}
+	versionDir = filepath.Join(baselineDir, version)
+	manifestPath := filepath.Join(versionDir, baselineManifestName)
+	content, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return nil, "", &LocalIOError{Path: manifestPath, Err: err}
+	}
+	manifest = &ScreenshotManifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, "", &LocalIOError{Path: manifestPath, Err: err}
+	}
+	return manifest, versionDir, nil
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApproveBaseline(t *testing.T) {
+	baselineDir, err := ioutil.TempDir("", "magic-pod-baseline-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(baselineDir)
+	// versions which are not in the form of v<number> are ignored
+	for _, name := range []string{"v10", "v2", "old", ".approving-1"} {
+		if err := os.Mkdir(filepath.Join(baselineDir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := BaselineVersions(baselineDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"v2", "v10"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("versions = %v, want %v", versions, want)
+	}
+
+	srcDir, err := ioutil.TempDir(baselineDir, ".approving-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &ScreenshotManifest{
+		Batch_Run_Number:    3,
+		File_Index_Type:     BaselineFileIndexType,
+		File_Name_Body_Type: BaselineFileNameBodyType,
+		Screenshots:         []Screenshot{{Path: "1/3_login.png", Archive_Path: "1/3_login.png", Test_Case_Number: 1, Line_Number: 3, Screenshot_Name: "login"}},
+	}
+	version, err := ApproveBaseline(baselineDir, srcDir, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "v11" {
+		t.Errorf("version = %s, want v11", version)
+	}
+	info, err := os.Stat(filepath.Join(baselineDir, version))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("mode of %s is %s, want 0755", version, info.Mode().Perm())
+	}
+
+	loaded, versionDir, err := LoadBaseline(baselineDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versionDir != filepath.Join(baselineDir, "v11") {
+		t.Errorf("versionDir = %s, want the latest version", versionDir)
+	}
+	if !reflect.DeepEqual(loaded, manifest) {
+		t.Errorf("manifest = %+v, want %+v", loaded, manifest)
+	}
+}
+
+func TestLoadBaselineError(t *testing.T) {
+	baselineDir, err := ioutil.TempDir("", "magic-pod-baseline-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(baselineDir)
+	tests := []struct {
+		name        string
+		baselineDir string
+		version     string
+	}{
+		{name: "no version", baselineDir: baselineDir},
+		{name: "missing baseline directory", baselineDir: filepath.Join(baselineDir, "missing")},
+		{name: "missing version", baselineDir: baselineDir, version: "v1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := LoadBaseline(test.baselineDir, test.version)
+			if _, ok := err.(*LocalIOError); !ok {
+				t.Errorf("err = %v, want *LocalIOError", err)
+			}
+		})
+	}
+}
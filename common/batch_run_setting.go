@@ -0,0 +1,327 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// RunSetting is a setting which can be passed to StartBatchRunWithSetting.
+// It is implemented by *BatchRunSetting and *CrossBatchRunSetting
+type RunSetting interface {
+	json.Marshaler
+	endpoint() string
+}
+
+// BatchRunSetting stands for the setting of a batch run, or an element of test_settings of a cross batch run
+type BatchRunSetting struct {
+	Environment       string `json:"environment,omitempty"`
+	Os                string `json:"os,omitempty"`
+	Device_Type       string `json:"device_type,omitempty"`
+	Version           string `json:"version,omitempty"`
+	Model             string `json:"model,omitempty"`
+	App_Type          string `json:"app_type,omitempty"`
+	App_Url           string `json:"app_url,omitempty"`
+	App_File_Number   int    `json:"app_file_number,omitempty"`
+	Test_Case_Numbers []int  `json:"test_case_numbers,omitempty"`
+	// Extra holds keys not defined above so that they are sent to the server as they are
+	Extra map[string]interface{} `json:"-"`
+}
+
+// CrossBatchRunSetting stands for the setting of a cross batch run which executes test cases with multiple test settings
+type CrossBatchRunSetting struct {
+	Test_Settings_Number int                `json:"test_settings_number,omitempty"`
+	Test_Settings        []*BatchRunSetting `json:"test_settings,omitempty"`
+	Concurrency          int                `json:"concurrency,omitempty"`
+	// Extra holds keys not defined above so that they are sent to the server as they are
+	Extra map[string]interface{} `json:"-"`
+}
+
+// NewBatchRunSetting creates an empty BatchRunSetting to be built by With... methods
+func NewBatchRunSetting() *BatchRunSetting {
+	return &BatchRunSetting{}
+}
+
+// WithDevice sets the environment and the device to execute test cases
+func (s *BatchRunSetting) WithDevice(environment string, os string, deviceType string, version string, model string) *BatchRunSetting {
+	s.Environment = environment
+	s.Os = os
+	s.Device_Type = deviceType
+	s.Version = version
+	s.Model = model
+	return s
+}
+
+// WithAppURL makes test cases use the app downloaded from the URL
+func (s *BatchRunSetting) WithAppURL(appURL string) *BatchRunSetting {
+	s.App_Type = "app_url"
+	s.App_Url = appURL
+	return s
+}
+
+// WithAppFileNumber makes test cases use the app uploaded by UploadApp
+func (s *BatchRunSetting) WithAppFileNumber(appFileNumber int) *BatchRunSetting {
+	s.App_Type = "app_file"
+	s.App_File_Number = appFileNumber
+	return s
+}
+
+// WithTestCaseNumbers restricts test cases to execute
+func (s *BatchRunSetting) WithTestCaseNumbers(testCaseNumbers ...int) *BatchRunSetting {
+	s.Test_Case_Numbers = testCaseNumbers
+	return s
+}
+
+// WithExtra sets a key which is not defined as a field
+func (s *BatchRunSetting) WithExtra(key string, value interface{}) *BatchRunSetting {
+	if s.Extra == nil {
+		s.Extra = make(map[string]interface{})
+	}
+	s.Extra[key] = value
+	return s
+}
+
+// NewCrossBatchRunSetting creates an empty CrossBatchRunSetting to be built by With... methods
+func NewCrossBatchRunSetting() *CrossBatchRunSetting {
+	return &CrossBatchRunSetting{}
+}
+
+// WithTestSettingsNumber uses the test settings defined in the project batch run page
+func (s *CrossBatchRunSetting) WithTestSettingsNumber(testSettingsNumber int) *CrossBatchRunSetting {
+	s.Test_Settings_Number = testSettingsNumber
+	return s
+}
+
+// AddTestSetting adds a test setting executed in the cross batch run
+func (s *CrossBatchRunSetting) AddTestSetting(testSetting *BatchRunSetting) *CrossBatchRunSetting {
+	s.Test_Settings = append(s.Test_Settings, testSetting)
+	return s
+}
+
+// WithAppFileNumber makes every test setting use the app uploaded by UploadApp.
+// If no test setting is added, a test setting only with the app is added so that it is applied to the test settings
+// defined in the project batch run page
+func (s *CrossBatchRunSetting) WithAppFileNumber(appFileNumber int) *CrossBatchRunSetting {
+	if len(s.Test_Settings) == 0 {
+		s.Test_Settings = []*BatchRunSetting{NewBatchRunSetting()}
+	}
+	for _, testSetting := range s.Test_Settings {
+		testSetting.WithAppFileNumber(appFileNumber)
+	}
+	return s
+}
+
+// WithConcurrency sets how many test settings are executed in parallel
+func (s *CrossBatchRunSetting) WithConcurrency(concurrency int) *CrossBatchRunSetting {
+	s.Concurrency = concurrency
+	return s
+}
+
+// WithExtra sets a key which is not defined as a field
+func (s *CrossBatchRunSetting) WithExtra(key string, value interface{}) *CrossBatchRunSetting {
+	if s.Extra == nil {
+		s.Extra = make(map[string]interface{})
+	}
+	s.Extra[key] = value
+	return s
+}
+
+func (s *BatchRunSetting) endpoint() string {
+	return "/{organization}/{project}/batch-run/"
+}
+
+func (s *CrossBatchRunSetting) endpoint() string {
+	return "/{organization}/{project}/cross-batch-run/"
+}
+
+// jsonFieldTypes returns JSON keys of the fields of the struct type with the types of the fields
+func jsonFieldTypes(structType reflect.Type) map[string]reflect.Type {
+	types := make(map[string]reflect.Type)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			types[name] = field.Type
+		}
+	}
+	return types
+}
+
+// marshalWithExtra encodes fields (a struct without MarshalJSON) together with extra keys
+func marshalWithExtra(fields interface{}, extra map[string]interface{}) ([]byte, error) {
+	fieldsBytes, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]interface{})
+	for k, v := range extra {
+		merged[k] = v
+	}
+	if err := json.Unmarshal(fieldsBytes, &merged); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merged)
+}
+
+// unmarshalWithExtra decodes data into fields (a pointer to a struct without UnmarshalJSON),
+// and returns keys which do not correspond to the fields. Values which do not fit the type of the field
+// (e.g. "model": 123) are also returned as extra keys, so that they are sent to the server as they are
+func unmarshalWithExtra(data []byte, fields interface{}) (map[string]interface{}, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	fieldTypes := jsonFieldTypes(reflect.TypeOf(fields).Elem())
+	known := make(map[string]json.RawMessage)
+	var extra map[string]interface{}
+	for key, value := range raw {
+		if fieldType, ok := fieldTypes[key]; ok && json.Unmarshal(value, reflect.New(fieldType).Interface()) == nil {
+			known[key] = value
+			continue
+		}
+		// keep numbers as written
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		decoder.UseNumber()
+		var extraValue interface{}
+		if err := decoder.Decode(&extraValue); err != nil {
+			return nil, err
+		}
+		if extra == nil {
+			extra = make(map[string]interface{})
+		}
+		extra[key] = extraValue
+	}
+	knownBytes, err := json.Marshal(known)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(knownBytes, fields); err != nil {
+		return nil, err
+	}
+	return extra, nil
+}
+
+// batchRunSettingFields has the same fields as BatchRunSetting without its methods
+type batchRunSettingFields BatchRunSetting
+
+// crossBatchRunSettingFields has the same fields as CrossBatchRunSetting without its methods
+type crossBatchRunSettingFields CrossBatchRunSetting
+
+// MarshalJSON encodes the setting including Extra
+func (s *BatchRunSetting) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra((*batchRunSettingFields)(s), s.Extra)
+}
+
+// UnmarshalJSON decodes the setting keeping unknown keys in Extra.
+// app_file_number can be also a numeric string like "123", and version can be also a number like 14.0
+func (s *BatchRunSetting) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	converted := false
+	if appFileNumber, ok := raw["app_file_number"]; ok {
+		var str string
+		if json.Unmarshal(appFileNumber, &str) == nil {
+			if number, err := strconv.Atoi(str); err == nil {
+				raw["app_file_number"] = json.RawMessage(strconv.Itoa(number))
+				converted = true
+			}
+		}
+	}
+	if version, ok := raw["version"]; ok {
+		var number float64
+		if json.Unmarshal(version, &number) == nil {
+			// keep the text as written, e.g. 14.0 becomes "14.0"
+			raw["version"], _ = json.Marshal(strings.TrimSpace(string(version)))
+			converted = true
+		}
+	}
+	if converted {
+		var err error
+		if data, err = json.Marshal(raw); err != nil {
+			return err
+		}
+	}
+	var fields batchRunSettingFields
+	extra, err := unmarshalWithExtra(data, &fields)
+	if err != nil {
+		return err
+	}
+	*s = BatchRunSetting(fields)
+	s.Extra = extra
+	return nil
+}
+
+// MarshalJSON encodes the setting including Extra
+func (s *CrossBatchRunSetting) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra((*crossBatchRunSettingFields)(s), s.Extra)
+}
+
+// UnmarshalJSON decodes the setting keeping unknown keys in Extra
+func (s *CrossBatchRunSetting) UnmarshalJSON(data []byte) error {
+	var fields crossBatchRunSettingFields
+	extra, err := unmarshalWithExtra(data, &fields)
+	if err != nil {
+		return err
+	}
+	*s = CrossBatchRunSetting(fields)
+	s.Extra = extra
+	return nil
+}
+
+// ParseRunSetting converts a setting in JSON format with --test_settings_number into a RunSetting.
+// The setting becomes a cross batch run if testSettingsNumber is not 0 or it has test_settings or test_settings_number.
+// Then keys outside of test_settings are moved into test_settings so that they are applied with testSettingsNumber
+func ParseRunSetting(testSettingsNumber int, setting string) (RunSetting, error) {
+	if setting == "" {
+		return NewCrossBatchRunSetting().WithTestSettingsNumber(testSettingsNumber), nil
+	}
+	var settingMap map[string]interface{}
+	if err := json.Unmarshal([]byte(setting), &settingMap); err != nil {
+		return nil, &SettingError{Message: fmt.Sprintf("setting must be a JSON object: %s", err)}
+	}
+	_, hasTestSettings := settingMap["test_settings"]
+	_, hasTestSettingsNumber := settingMap["test_settings_number"]
+	if testSettingsNumber == 0 && !hasTestSettings && !hasTestSettingsNumber {
+		// normal batch run
+		var batchRunSetting BatchRunSetting
+		if err := json.Unmarshal([]byte(setting), &batchRunSetting); err != nil {
+			return nil, &SettingError{Message: fmt.Sprintf("invalid setting: %s", err)}
+		}
+		return &batchRunSetting, nil
+	}
+
+	var crossBatchRunSetting CrossBatchRunSetting
+	if err := json.Unmarshal([]byte(setting), &crossBatchRunSetting); err != nil {
+		return nil, &SettingError{Message: fmt.Sprintf("invalid setting: %s", err)}
+	}
+	if testSettingsNumber == 0 {
+		return &crossBatchRunSetting, nil
+	}
+	if hasTestSettingsNumber && testSettingsNumber != crossBatchRunSetting.Test_Settings_Number {
+		return nil, &SettingError{Message: "--test_settings_number and --setting have different number"}
+	}
+	crossBatchRunSetting.Test_Settings_Number = testSettingsNumber
+	if !hasTestSettings {
+		// convert {"model":"Nexus 5X"} to {"test_settings":[{"model":"Nexus 5X"}]}
+		// so that it can be treated with test_settings_number
+		var testSetting BatchRunSetting
+		if err := json.Unmarshal([]byte(setting), &testSetting); err != nil {
+			return nil, &SettingError{Message: fmt.Sprintf("invalid setting: %s", err)}
+		}
+		delete(testSetting.Extra, "test_settings_number")
+		delete(testSetting.Extra, "concurrency")
+		if len(testSetting.Extra) == 0 {
+			testSetting.Extra = nil
+		}
+		if !reflect.DeepEqual(testSetting, BatchRunSetting{}) {
+			crossBatchRunSetting.Test_Settings = []*BatchRunSetting{&testSetting}
+		}
+		crossBatchRunSetting.Extra = nil
+	}
+	return &crossBatchRunSetting, nil
+}
@@ -0,0 +1,190 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRunSetting(t *testing.T) {
+	tests := []struct {
+		name               string
+		testSettingsNumber int
+		setting            string
+		wantCross          bool
+		want               string
+	}{
+		// examples in README
+		{
+			name:               "test settings number with app file number",
+			testSettingsNumber: 1,
+			setting:            `{"app_file_number":"5"}`,
+			wantCross:          true,
+			want:               `{"test_settings_number":1,"test_settings":[{"app_file_number":5}]}`,
+		},
+		{
+			name:    "batch run",
+			setting: `{"environment":"magic_pod","os":"ios","device_type":"simulator","version":"13.1","model":"iPhone 8","app_type":"app_url","app_url":"https://example.com/app.zip"}`,
+			want:    `{"environment":"magic_pod","os":"ios","device_type":"simulator","version":"13.1","model":"iPhone 8","app_type":"app_url","app_url":"https://example.com/app.zip"}`,
+		},
+		{
+			name:      "cross batch run",
+			setting:   `{"test_settings":[{"os":"ios","version":"13.1","model":"iPhone 8"},{"os":"ios","version":"13.1","model":"iPhone X"}],"concurrency":1}`,
+			wantCross: true,
+			want:      `{"test_settings":[{"os":"ios","version":"13.1","model":"iPhone 8"},{"os":"ios","version":"13.1","model":"iPhone X"}],"concurrency":1}`,
+		},
+		// --test_settings_number and --setting
+		{
+			name:               "only test settings number",
+			testSettingsNumber: 2,
+			wantCross:          true,
+			want:               `{"test_settings_number":2}`,
+		},
+		{
+			name:               "concurrency is kept at the top level",
+			testSettingsNumber: 2,
+			setting:            `{"concurrency":2}`,
+			wantCross:          true,
+			want:               `{"test_settings_number":2,"concurrency":2}`,
+		},
+		{
+			name:               "keys are moved into test_settings",
+			testSettingsNumber: 2,
+			setting:            `{"version":14,"app_file_number":"7","concurrency":1,"send_mail":true}`,
+			wantCross:          true,
+			want:               `{"test_settings_number":2,"concurrency":1,"test_settings":[{"version":"14","app_file_number":7,"send_mail":true}]}`,
+		},
+		{
+			name:               "test_settings is kept",
+			testSettingsNumber: 2,
+			setting:            `{"test_settings":[{"model":"Pixel 4"}]}`,
+			wantCross:          true,
+			want:               `{"test_settings_number":2,"test_settings":[{"model":"Pixel 4"}]}`,
+		},
+		{
+			name:               "the same test settings number",
+			testSettingsNumber: 2,
+			setting:            `{"test_settings_number":2,"model":"Pixel 4"}`,
+			wantCross:          true,
+			want:               `{"test_settings_number":2,"test_settings":[{"model":"Pixel 4"}]}`,
+		},
+		{
+			name:      "test settings number only in the setting",
+			setting:   `{"test_settings_number":3,"model":"Pixel 4"}`,
+			wantCross: true,
+			want:      `{"test_settings_number":3,"model":"Pixel 4"}`,
+		},
+		// values which do not fit the fields are sent as they are
+		{
+			name:    "numeric version",
+			setting: `{"version":14}`,
+			want:    `{"version":"14"}`,
+		},
+		{
+			name:    "numeric version keeps its text",
+			setting: `{"version":14.0}`,
+			want:    `{"version":"14.0"}`,
+		},
+		{
+			name:    "unknown keys",
+			setting: `{"model":"iPhone 8","send_mail":true,"large":12345678901234567890}`,
+			want:    `{"model":"iPhone 8","send_mail":true,"large":12345678901234567890}`,
+		},
+		{
+			name:    "wrong types",
+			setting: `{"model":123,"test_case_numbers":"1,2","app_file_number":"${FILE_NO}"}`,
+			want:    `{"model":123,"test_case_numbers":"1,2","app_file_number":"${FILE_NO}"}`,
+		},
+		{
+			name:      "wrong types in test_settings",
+			setting:   `{"test_settings":[{"version":13.1,"model":true}],"concurrency":"2"}`,
+			wantCross: true,
+			want:      `{"test_settings":[{"version":"13.1","model":true}],"concurrency":"2"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setting, err := ParseRunSetting(test.testSettingsNumber, test.setting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, isCross := setting.(*CrossBatchRunSetting); isCross != test.wantCross {
+				t.Errorf("setting is %T", setting)
+			}
+			got, err := json.Marshal(setting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameJSON(t, string(got), test.want)
+		})
+	}
+}
+
+func TestParseRunSettingError(t *testing.T) {
+	tests := []struct {
+		name               string
+		testSettingsNumber int
+		setting            string
+	}{
+		{name: "invalid JSON", setting: `{"model":`},
+		{name: "not an object", setting: `["model"]`},
+		{name: "different test settings number", testSettingsNumber: 2, setting: `{"test_settings_number":3}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseRunSetting(test.testSettingsNumber, test.setting)
+			var settingErr *SettingError
+			if !errors.As(err, &settingErr) {
+				t.Errorf("err = %v, want *SettingError", err)
+			}
+		})
+	}
+}
+
+func TestLoadSettingFileKeepsVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-setting-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		fileName string
+		content  string
+		want     string
+	}{
+		{
+			fileName: "setting.yaml",
+			content:  "version: 14.0\ntest_settings:\n  - {version: 13.10, model: iPhone 8}\n  - {version: \"12.0\"}\n",
+			want:     `{"version":"14.0","test_settings":[{"version":"13.10","model":"iPhone 8"},{"version":"12.0"}]}`,
+		},
+		{
+			fileName: "setting.json",
+			content:  `{"version": 14.0, "app_file_number": ${FILE_NO}}`,
+			want:     `{"version":"14.0","app_file_number":3}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.fileName, func(t *testing.T) {
+			settingPath := filepath.Join(dir, test.fileName)
+			if err := ioutil.WriteFile(settingPath, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			setting, err := LoadSettingFile(settingPath, map[string]string{"FILE_NO": "3"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			runSetting, err := ParseRunSetting(0, setting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(runSetting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameJSON(t, string(got), test.want)
+		})
+	}
+}
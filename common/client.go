@@ -0,0 +1,456 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty"
+)
+
+// DefaultURLBase is the URL of Magic Pod used when no other URL is specified
+const DefaultURLBase = "https://magic-pod.com"
+
+// Client stands for a connection to Magic Pod Web API for a specific organization and project.
+// It is safe to reuse one Client for many API calls.
+type Client struct {
+	urlBase          string
+	apiToken         string
+	organization     string
+	project          string
+	httpHeaders      map[string]string
+	httpClient       *http.Client
+	timeout          time.Duration
+	retryPolicy      RetryPolicy
+	onUploadProgress UploadProgressFunc
+	uploadCachePath  string
+	onCacheWarning   func(err error)
+	restyClient      *resty.Client
+}
+
+// ClientOption changes optional settings of a Client
+type ClientOption func(*Client)
+
+// WithURLBase changes the URL of Magic Pod. DefaultURLBase is used if not specified
+func WithURLBase(urlBase string) ClientOption {
+	return func(c *Client) {
+		c.urlBase = urlBase
+	}
+}
+
+// WithHTTPHeaders adds HTTP headers sent with every request
+func WithHTTPHeaders(httpHeaders map[string]string) ClientOption {
+	return func(c *Client) {
+		for k, v := range httpHeaders {
+			c.httpHeaders[k] = v
+		}
+	}
+}
+
+// WithHTTPClient makes the Client send requests through the specified http.Client
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout sets the time limit of each HTTP request. 0 means no limit
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// NewClient creates a Client for the specified organization and project
+func NewClient(apiToken string, organization string, project string, options ...ClientOption) *Client {
+	c := &Client{
+		urlBase:      DefaultURLBase,
+		apiToken:     apiToken,
+		organization: organization,
+		project:      project,
+		httpHeaders:  make(map[string]string),
+		retryPolicy:  DefaultRetryPolicy(),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	if c.httpClient != nil {
+		// resty sets the timeout and the redirect policy on the http.Client itself, so copy it not to change the caller's one.
+		// The copy still shares the Transport and its connections
+		httpClient := *c.httpClient
+		c.restyClient = resty.NewWithClient(&httpClient)
+	} else {
+		c.restyClient = resty.New()
+	}
+	if c.timeout > 0 {
+		c.restyClient.SetTimeout(c.timeout)
+	}
+	c.restyClient.
+		SetPreRequestHook(setStreamContentLength).
+		SetHostURL(c.urlBase+"/api/v1.0").
+		SetHeader("Authorization", "Token "+c.apiToken).
+		SetHeaders(c.httpHeaders).
+		SetPathParams(map[string]string{
+			"organization": c.organization,
+			"project":      c.project,
+		})
+	return c
+}
+
+func (c *Client) createBaseRequest(ctx context.Context) *resty.Request {
+	return c.restyClient.R().SetContext(ctx)
+}
+
+// UploadApp uploads app/ipa/apk/aab file, or zips and uploads .app directory to the server
+func (c *Client) UploadApp(appPath string) (int, error) {
+	return c.UploadAppContext(context.Background(), appPath)
+}
+
+// UploadAppContext is the same as UploadApp except that the request is canceled when ctx is done
+func (c *Client) UploadAppContext(ctx context.Context, appPath string) (int, error) {
+	if err := ValidateAppPath(appPath); err != nil {
+		return 0, err
+	}
+	actualPath := appPath
+	if strings.ToLower(filepath.Ext(filepath.Clean(appPath))) == ".app" {
+		zipPath, cleanup, err := zipAppDir(appPath)
+		if err != nil {
+			return 0, err
+		}
+		defer cleanup()
+		actualPath = zipPath
+	}
+	// stream the file from disk so that large files are not loaded into memory
+	body, err := newMultipartFileBody("file", actualPath, c.onUploadProgress)
+	if err != nil {
+		return 0, err
+	}
+	defer body.close()
+	req := c.createBaseRequest(ctx).
+		SetHeader("Content-Type", body.contentType).
+		SetHeader(streamLengthHeader, strconv.FormatInt(body.contentLength(), 10)).
+		SetBody(body).
+		SetResult(UploadFile{})
+	res, err := c.execute(ctx, req, resty.MethodPost, "/{organization}/{project}/upload-file/")
+	if err := handleError(res, err); err != nil {
+		return 0, err
+	}
+	return res.Result().(*UploadFile).File_No, nil
+}
+
+// StartBatchRun starts a batch run or a cross batch run on the server
+func (c *Client) StartBatchRun(testSettingsNumber int, setting string) (*BatchRun, error) {
+	return c.StartBatchRunContext(context.Background(), testSettingsNumber, setting)
+}
+
+// StartBatchRunContext is the same as StartBatchRun except that the request is canceled when ctx is done
+func (c *Client) StartBatchRunContext(ctx context.Context, testSettingsNumber int, setting string) (*BatchRun, error) {
+	runSetting, err := ParseRunSetting(testSettingsNumber, setting)
+	if err != nil {
+		return nil, err
+	}
+	return c.StartBatchRunWithSettingContext(ctx, runSetting)
+}
+
+// StartBatchRunWithSetting starts a batch run with *BatchRunSetting, or a cross batch run with *CrossBatchRunSetting
+func (c *Client) StartBatchRunWithSetting(setting RunSetting) (*BatchRun, error) {
+	return c.StartBatchRunWithSettingContext(context.Background(), setting)
+}
+
+// StartBatchRunWithSettingContext is the same as StartBatchRunWithSetting except that the request is canceled when ctx is done
+func (c *Client) StartBatchRunWithSettingContext(ctx context.Context, setting RunSetting) (*BatchRun, error) {
+	req := c.createBaseRequest(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(setting).
+		SetResult(BatchRun{})
+	res, err := c.execute(ctx, req, resty.MethodPost, setting.endpoint())
+	if err := handleError(res, err); err != nil {
+		return nil, err
+	}
+	return res.Result().(*BatchRun), nil
+}
+
+// GetBatchRun retrieves status and number of test cases executed of a specified batch run
+func (c *Client) GetBatchRun(batchRunNumber int) (*BatchRun, error) {
+	return c.GetBatchRunContext(context.Background(), batchRunNumber)
+}
+
+// GetBatchRunContext is the same as GetBatchRun except that the request is canceled when ctx is done
+func (c *Client) GetBatchRunContext(ctx context.Context, batchRunNumber int) (*BatchRun, error) {
+	req := c.createBaseRequest(ctx).
+		SetPathParams(map[string]string{
+			"batch_run_number": strconv.Itoa(batchRunNumber),
+		}).
+		SetResult(BatchRun{})
+	res, err := c.execute(ctx, req, resty.MethodGet, "/{organization}/{project}/batch-run/{batch_run_number}/")
+	if err := handleError(res, err); err != nil {
+		return nil, err
+	}
+	return res.Result().(*BatchRun), nil
+}
+
+// StopBatchRun stops a running batch run or cross batch run on the server
+func (c *Client) StopBatchRun(batchRunNumber int) error {
+	return c.StopBatchRunContext(context.Background(), batchRunNumber)
+}
+
+// StopBatchRunContext is the same as StopBatchRun except that the request is canceled when ctx is done
+func (c *Client) StopBatchRunContext(ctx context.Context, batchRunNumber int) error {
+	req := c.createBaseRequest(ctx).
+		SetPathParams(map[string]string{
+			"batch_run_number": strconv.Itoa(batchRunNumber),
+		})
+	res, err := c.execute(ctx, req, resty.MethodPost, "/{organization}/{project}/batch-run/{batch_run_number}/stop/")
+	return handleError(res, err)
+}
+
+// LatestBatchRunNo retrieves the number of the latest batch run in the project
+func (c *Client) LatestBatchRunNo() (int, error) {
+	return c.LatestBatchRunNoContext(context.Background())
+}
+
+// LatestBatchRunNoContext is the same as LatestBatchRunNo except that the request is canceled when ctx is done
+func (c *Client) LatestBatchRunNoContext(ctx context.Context) (int, error) {
+	req := c.createBaseRequest(ctx).
+		SetQueryParam("count", "1").
+		SetResult(BatchRuns{})
+	res, err := c.execute(ctx, req, resty.MethodGet, "/{organization}/{project}/batch-runs/")
+	if err := handleError(res, err); err != nil {
+		return 0, err
+	}
+	batchRuns := res.Result().(*BatchRuns).Batch_Runs
+	if len(batchRuns) == 0 {
+		return 0, ErrNoBatchRun
+	}
+	return batchRuns[0].Batch_Run_Number, nil
+}
+
+// ListApps retrieves app/ipa/apk files currently stored in the project
+func (c *Client) ListApps() ([]AppFile, error) {
+	return c.ListAppsContext(context.Background())
+}
+
+// ListAppsContext is the same as ListApps except that the request is canceled when ctx is done
+func (c *Client) ListAppsContext(ctx context.Context) ([]AppFile, error) {
+	req := c.createBaseRequest(ctx).
+		SetResult(AppFiles{})
+	res, err := c.execute(ctx, req, resty.MethodGet, "/{organization}/{project}/list-files/")
+	if err := handleError(res, err); err != nil {
+		return nil, err
+	}
+	return res.Result().(*AppFiles).App_Files, nil
+}
+
+// DeleteApp deletes app/ipa/apk file on the server
+func (c *Client) DeleteApp(appFileNumber int) error {
+	return c.DeleteAppContext(context.Background(), appFileNumber)
+}
+
+// DeleteAppContext is the same as DeleteApp except that the request is canceled when ctx is done
+func (c *Client) DeleteAppContext(ctx context.Context, appFileNumber int) error {
+	req := c.createBaseRequest(ctx).
+		SetBody(fmt.Sprintf("{\"app_file_number\":%d}", appFileNumber))
+	res, err := c.execute(ctx, req, resty.MethodDelete, "/{organization}/{project}/delete-file/")
+	if err := handleError(res, err); err != nil {
+		return err
+	}
+	// the file has been deleted anyway, so a failure of the cache is not an error
+	if err := c.forgetUploadedFile(appFileNumber); err != nil {
+		c.warnUploadCache(err)
+	}
+	return nil
+}
+
+// GetScreenshots downloads screenshots of a batch run as a zip file into downloadPath.
+// An interrupted download is resumed from downloadPath.part if the server supports it
+func (c *Client) GetScreenshots(batchRunNumber int, downloadPath string, fileIndexType string, fileNameBodyType string, downloadType string, maskDynamicallyChangedArea bool) error {
+	return c.GetScreenshotsContext(context.Background(), batchRunNumber, downloadPath, fileIndexType, fileNameBodyType, downloadType, maskDynamicallyChangedArea)
+}
+
+// GetScreenshotsContext is the same as GetScreenshots except that the request is canceled when ctx is done
+func (c *Client) GetScreenshotsContext(ctx context.Context, batchRunNumber int, downloadPath string, fileIndexType string, fileNameBodyType string, downloadType string, maskDynamicallyChangedArea bool) error {
+	var maskDynamicallyChangedAreaStr string
+	if maskDynamicallyChangedArea {
+		maskDynamicallyChangedAreaStr = "true"
+	} else {
+		maskDynamicallyChangedAreaStr = "false"
+	}
+	newRequest := func() *resty.Request {
+		return c.createBaseRequest(ctx).
+			SetPathParams(map[string]string{
+				"batch_run_number": strconv.Itoa(batchRunNumber),
+			}).
+			SetQueryParam("file_index_type", fileIndexType).
+			SetQueryParam("file_name_body_type", fileNameBodyType).
+			SetQueryParam("download_type", downloadType).
+			SetQueryParam("mask_dynamically_changed_area", maskDynamicallyChangedAreaStr)
+	}
+	requestKey := fmt.Sprintf("%s %s/%s screenshots %d %s %s %s %s", c.urlBase, c.organization, c.project,
+		batchRunNumber, fileIndexType, fileNameBodyType, downloadType, maskDynamicallyChangedAreaStr)
+	return c.downloadToFile(ctx, newRequest, "/{organization}/{project}/batch-runs/{batch_run_number}/screenshots/", downloadPath, requestKey)
+}
+
+// DownloadScreenshots downloads screenshots of a batch run and extracts them into destDir with the layout.
+// The zip file is removed after the extraction
+func (c *Client) DownloadScreenshots(batchRunNumber int, destDir string, fileIndexType string, fileNameBodyType string, downloadType string, maskDynamicallyChangedArea bool, layout string) ([]Screenshot, error) {
+	return c.DownloadScreenshotsContext(context.Background(), batchRunNumber, destDir, fileIndexType, fileNameBodyType, downloadType, maskDynamicallyChangedArea, layout)
+}
+
+// DownloadScreenshotsContext is the same as DownloadScreenshots except that the request is canceled when ctx is done
+func (c *Client) DownloadScreenshotsContext(ctx context.Context, batchRunNumber int, destDir string, fileIndexType string, fileNameBodyType string, downloadType string, maskDynamicallyChangedArea bool, layout string) ([]Screenshot, error) {
+	tmpDir, err := ioutil.TempDir("", "magic-pod-screenshots-")
+	if err != nil {
+		return nil, &LocalIOError{Path: os.TempDir(), Err: err}
+	}
+	defer os.RemoveAll(tmpDir)
+	zipPath := filepath.Join(tmpDir, "screenshots.zip")
+	if err := c.GetScreenshotsContext(ctx, batchRunNumber, zipPath, fileIndexType, fileNameBodyType, downloadType, maskDynamicallyChangedArea); err != nil {
+		return nil, err
+	}
+	return ExtractScreenshots(zipPath, destDir, fileIndexType, fileNameBodyType, layout)
+}
+
+// ExecuteBatchRun starts batch run(s) and wait for its completion with showing progress
+func (c *Client) ExecuteBatchRun(testSettingsNumber int, setting string,
+	waitForResult bool, waitLimit int, printResult bool) (*BatchRun /*on which magic-pod bitrise step depends */, bool, bool, error) {
+	return c.ExecuteBatchRunContext(context.Background(), testSettingsNumber, setting, waitForResult, waitLimit, printResult)
+}
+
+// ExecuteBatchRunContext is the same as ExecuteBatchRun except that the requests and the wait are canceled when ctx is done
+func (c *Client) ExecuteBatchRunContext(ctx context.Context, testSettingsNumber int, setting string,
+	waitForResult bool, waitLimit int, printResult bool) (*BatchRun, bool, bool, error) {
+	runSetting, err := ParseRunSetting(testSettingsNumber, setting)
+	if err != nil {
+		return nil, false, false, err
+	}
+	return c.ExecuteBatchRunWithSettingContext(ctx, runSetting, waitForResult, waitLimit, printResult)
+}
+
+// ExecuteBatchRunWithSetting is the same as ExecuteBatchRun except that it takes a typed setting
+func (c *Client) ExecuteBatchRunWithSetting(setting RunSetting, waitForResult bool, waitLimit int, printResult bool) (*BatchRun, bool, bool, error) {
+	return c.ExecuteBatchRunWithSettingContext(context.Background(), setting, waitForResult, waitLimit, printResult)
+}
+
+// ExecuteBatchRunWithSettingContext is the same as ExecuteBatchRunWithSetting except that the requests and the wait are canceled when ctx is done
+func (c *Client) ExecuteBatchRunWithSettingContext(ctx context.Context, setting RunSetting,
+	waitForResult bool, waitLimit int, printResult bool) (*BatchRun, bool, bool, error) {
+	// send batch run start request
+	batchRun, err := c.StartBatchRunWithSettingContext(ctx, setting)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	crossBatchRunTotalTestCount := batchRun.Test_Cases.Total
+	printMessage(printResult, "test result page:\n")
+	printMessage(printResult, "%s\n", batchRun.Url)
+
+	// finish before the test finish
+	if !waitForResult {
+		return batchRun, false, false, nil
+	}
+
+	const initRetryInterval = 10 // retry more frequently at first
+	const retryInterval = 60
+	var limitSeconds int
+	if waitLimit == 0 {
+		limitSeconds = crossBatchRunTotalTestCount * 10 * 60 // wait up to test count x 10 minutes by default
+	} else {
+		limitSeconds = waitLimit
+	}
+	passedSeconds := 0
+	existsErr := false
+	existsUnresolved := false
+	printMessage(printResult, "\n#%d wait until %d tests to be finished.. \n", batchRun.Batch_Run_Number, batchRun.Test_Cases.Total)
+	prevFinished := 0
+	for {
+		batchRunUnderProgress, err := c.GetBatchRunContext(ctx, batchRun.Batch_Run_Number)
+		if err != nil {
+			if ctx.Err() != nil {
+				return batchRun, existsErr, existsUnresolved, ctx.Err()
+			}
+			// give up the wait here. The batch run may be still running
+			printMessage(printResult, "\n")
+			return batchRun, existsErr, existsUnresolved, fmt.Errorf("failed to get the status of batch run #%d: %w", batchRun.Batch_Run_Number, err)
+		}
+		finished := batchRunUnderProgress.Test_Cases.Succeeded + batchRunUnderProgress.Test_Cases.Failed + batchRunUnderProgress.Test_Cases.Aborted + batchRunUnderProgress.Test_Cases.Unresolved
+		printMessage(printResult, ".") // show progress to prevent "long time no output" error on CircleCI etc
+		// output progress
+		if finished != prevFinished {
+			notSuccessfulCount := ""
+			if batchRunUnderProgress.Test_Cases.Failed > 0 {
+				notSuccessfulCount = fmt.Sprintf("%d failed", batchRunUnderProgress.Test_Cases.Failed)
+			}
+			if batchRunUnderProgress.Test_Cases.Unresolved > 0 {
+				if notSuccessfulCount != "" {
+					notSuccessfulCount += ", "
+				}
+				notSuccessfulCount += fmt.Sprintf("%d unresolved", batchRunUnderProgress.Test_Cases.Unresolved)
+			}
+			if notSuccessfulCount != "" {
+				notSuccessfulCount = fmt.Sprintf(" (%s)", notSuccessfulCount)
+			}
+			printMessage(printResult, "%d/%d finished%s\n", finished, batchRun.Test_Cases.Total, notSuccessfulCount)
+			prevFinished = finished
+		}
+		if batchRunUnderProgress.Status != "running" {
+			if batchRunUnderProgress.Test_Cases.Unresolved > 0 {
+				existsUnresolved = true
+			}
+			if batchRunUnderProgress.Status == "succeeded" {
+				printMessage(printResult, "batch run succeeded\n")
+				break
+			} else if batchRunUnderProgress.Status == "failed" {
+				if batchRunUnderProgress.Test_Cases.Failed > 0 {
+					unresolved := ""
+					if existsUnresolved {
+						unresolved = fmt.Sprintf(", %d unresolved", batchRunUnderProgress.Test_Cases.Unresolved)
+					}
+					printMessage(printResult, "batch run failed (%d failed%s)\n", batchRunUnderProgress.Test_Cases.Failed, unresolved)
+				} else {
+					printMessage(printResult, "batch run failed\n")
+				}
+				existsErr = true
+				break
+			} else if batchRunUnderProgress.Status == "unresolved" {
+				printMessage(printResult, "batch run unresolved (%d unresolved)\n", batchRunUnderProgress.Test_Cases.Unresolved)
+				break
+			} else if batchRunUnderProgress.Status == "aborted" {
+				printMessage(printResult, "batch run aborted\n")
+				existsErr = true
+				break
+			} else {
+				return batchRun, existsErr, existsUnresolved, &UnknownStatusError{BatchRunNumber: batchRun.Batch_Run_Number, Status: batchRunUnderProgress.Status}
+			}
+		}
+		if passedSeconds > limitSeconds {
+			return batchRun, existsErr, existsUnresolved, &WaitTimeoutError{BatchRunNumber: batchRun.Batch_Run_Number, WaitLimit: time.Duration(limitSeconds) * time.Second}
+		}
+		interval := retryInterval
+		if passedSeconds < 120 {
+			interval = initRetryInterval
+		}
+		if err := sleepContext(ctx, time.Duration(interval)*time.Second); err != nil {
+			return batchRun, existsErr, existsUnresolved, err
+		}
+		passedSeconds += interval
+	}
+	return batchRun, existsErr, existsUnresolved, nil
+}
+
+// sleepContext waits for the duration, or returns ctx.Err() as soon as ctx is done
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
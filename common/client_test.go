@@ -0,0 +1,40 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestClient returns a Client which sends requests to server without retry
+func newTestClient(server *httptest.Server, options ...ClientOption) *Client {
+	options = append([]ClientOption{WithURLBase(server.URL), WithRetryPolicy(RetryPolicy{MaxAttempts: 1})}, options...)
+	return NewClient("token", "org", "proj", options...)
+}
+
+func TestExecuteBatchRunReturnsPollError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`{"batch_run_number":5,"status":"running","test_cases":{"total":1}}`))
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"detail":"permission denied"}`))
+	}))
+	defer server.Close()
+
+	batchRun, existsErr, _, err := newTestClient(server).ExecuteBatchRunContext(context.Background(), 1, "", true, 0, false)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("err = %v, want *APIError of 403", err)
+	}
+	if batchRun == nil || batchRun.Batch_Run_Number != 5 {
+		t.Errorf("batchRun = %+v, want the started one", batchRun)
+	}
+	if existsErr {
+		t.Error("existsErr is true although the result is unknown")
+	}
+}
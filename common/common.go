@@ -1,17 +1,10 @@
 package common
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/go-resty/resty"
-	"github.com/mholt/archiver"
-	"github.com/urfave/cli"
 )
 
 // BatchRun stands for a batch run executed on the server
@@ -25,241 +18,138 @@ type BatchRun struct {
 		Aborted    int
 		Unresolved int
 		Total      int
+		Details    []BatchRunDetail
 	}
 }
 
-// BatchRuns stands for a group of batch runs executed on the server
-type BatchRuns struct {
-	Batch_Runs []BatchRun
+// BatchRunDetail stands for results of test cases executed with one test setting in a batch run.
+// A cross batch run has one detail for each test setting
+type BatchRunDetail struct {
+	Pattern_Name        string
+	Environment         string
+	Os                  string
+	Device_Type         string
+	Version             string
+	Model               string
+	Included_Test_Cases []TestCaseResult
 }
 
-// UploadFile stands for a file to be uploaded to the server
-type UploadFile struct {
-	File_No int
+// Device returns a human readable summary of the device used for the test setting
+func (d *BatchRunDetail) Device() string {
+	device := ""
+	for _, value := range []string{d.Model, d.Os, d.Version, d.Device_Type} {
+		if value == "" {
+			continue
+		}
+		if device != "" {
+			device += " "
+		}
+		device += value
+	}
+	return device
+}
+
+// TestCaseResult stands for the result of a test case executed in a batch run
+type TestCaseResult struct {
+	Number      int
+	Name        string
+	Status      string
+	Started_At  string
+	Finished_At string
+	Url         string
 }
 
-func zipAppDir(dirPath string) string {
-	zipPath := dirPath + ".zip"
-	if err := os.RemoveAll(zipPath); err != nil {
-		panic(err)
+// Duration returns how long the test case took. ok is false if it has not finished yet
+func (t *TestCaseResult) Duration() (duration time.Duration, ok bool) {
+	startedAt, err := time.Parse(time.RFC3339, t.Started_At)
+	if err != nil {
+		return 0, false
 	}
-	if err := archiver.Archive([]string{dirPath}, zipPath); err != nil {
-		panic(err)
+	finishedAt, err := time.Parse(time.RFC3339, t.Finished_At)
+	if err != nil {
+		return 0, false
 	}
-	return zipPath
+	return finishedAt.Sub(startedAt), true
 }
 
-func createBaseRequest(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string) *resty.Request {
-	client := resty.New()
-	return client.
-		SetHostURL(urlBase+"/api/v1.0").R().
-		SetHeader("Authorization", "Token "+string(apiToken)).
-		SetHeaders(httpHeadersMap).
-		SetPathParams(map[string]string{
-			"organization": organization,
-			"project":      project,
-		})
+// BatchRuns stands for a group of batch runs executed on the server
+type BatchRuns struct {
+	Batch_Runs []BatchRun
 }
 
-func handleError(resp *resty.Response) *cli.ExitError {
-	if resp.StatusCode() != 200 {
-		return cli.NewExitError(fmt.Sprintf("%s: %s", resp.Status(), resp.String()), 1)
-	} else {
-		return nil
-	}
+// AppFile stands for an app/ipa/apk file uploaded to the server
+type AppFile struct {
+	File_No     int
+	File_Name   string
+	Size        int64
+	Uploaded_At string
+	Uploaded_By string
 }
 
-// UploadApp uploads app/ipa/apk file to the server
-func UploadApp(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string, appPath string) (int, *cli.ExitError) {
-	stat, err := os.Stat(appPath)
+// UploadedAt returns when the file was uploaded. ok is false if the server did not return a valid time
+func (f *AppFile) UploadedAt() (uploadedAt time.Time, ok bool) {
+	uploadedAt, err := time.Parse(time.RFC3339, f.Uploaded_At)
 	if err != nil {
-		return 0, cli.NewExitError(fmt.Sprintf("%s does not exist", appPath), 1)
+		return time.Time{}, false
 	}
-	var actualPath string
-	if stat.Mode().IsDir() {
-		if strings.HasSuffix(appPath, ".app") {
-			actualPath = zipAppDir(appPath)
-		} else {
-			return 0, cli.NewExitError(fmt.Sprintf("%s is not file but direcoty.", appPath), 1)
-		}
-	} else {
-		actualPath = appPath
+	return uploadedAt, true
+}
+
+// AppFiles stands for a group of files uploaded to the server
+type AppFiles struct {
+	App_Files []AppFile
+}
+
+// UploadFile stands for a file to be uploaded to the server
+type UploadFile struct {
+	File_No int
+}
+
+func newClient(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string) *Client {
+	return NewClient(apiToken, organization, project, WithURLBase(urlBase), WithHTTPHeaders(httpHeadersMap))
+}
+
+func handleError(resp *resty.Response, err error) error {
+	if _, ok := err.(*LocalIOError); ok {
+		return err
 	}
-	res, err := createBaseRequest(urlBase, apiToken, organization, project, httpHeadersMap).
-		SetFile("file", actualPath).
-		SetResult(UploadFile{}).
-		Post("/{organization}/{project}/upload-file/")
 	if err != nil {
-		panic(err)
+		return &TransportError{Err: err}
 	}
-	if exitErr := handleError(res); exitErr != nil {
-		return 0, exitErr
+	if resp.StatusCode() != 200 {
+		return &APIError{StatusCode: resp.StatusCode(), Status: resp.Status(), Body: resp.String()}
 	}
-	return res.Result().(*UploadFile).File_No, nil
+	return nil
 }
 
-func mergeTestSettingsNumberToSetting(testSettingsMap map[string]interface{}, hasTestSettings bool, testSettingsNumber int) string {
-	testSettingsMap["test_settings_number"] = testSettingsNumber
-
-	if !hasTestSettings {
-		// convert {\"model\":\"Nexus 5X\"} to {\"test_settings\":[{\"model\":\"Nexus 5X\"}]}
-		// so that it can be treated with test_settings_number
-		miscSettings := make(map[string]interface{})
-		keysToDelete := []string{}
-		for k, v := range testSettingsMap {
-			if k != "test_settings_number" && k != "concurrency" {
-				miscSettings[k] = v
-				keysToDelete = append(keysToDelete, k)
-			}
-		}
-		for k := range keysToDelete {
-			delete(testSettingsMap, keysToDelete[k])
-		}
-		if len(miscSettings) > 0 {
-			settingsArray := [...]map[string]interface{}{miscSettings}
-			testSettingsMap["test_settings"] = settingsArray
-		}
-	}
-
-	settingBytes, _ := json.Marshal(testSettingsMap)
-	return string(settingBytes)
+// UploadApp uploads app/ipa/apk file to the server
+func UploadApp(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string, appPath string) (int, error) {
+	return newClient(urlBase, apiToken, organization, project, httpHeadersMap).UploadApp(appPath)
 }
 
 // StartBatchRun starts a batch run or a cross batch run on the server
-func StartBatchRun(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string, testSettingsNumber int, setting string) (*BatchRun, *cli.ExitError) {
-	var testSettings interface{}
-	isCrossBatchRunSetting := (testSettingsNumber != 0)
-	if setting == "" {
-		setting = "{\"test_settings_number\":" + strconv.Itoa(testSettingsNumber) + "}"
-	} else {
-		err := json.Unmarshal([]byte(setting), &testSettings)
-		if err == nil {
-			testSettingsMap, ok := testSettings.(map[string]interface{})
-			if ok {
-				_, hasTestSettings := testSettingsMap["test_settings"]
-				testSettingsNumberInJSON, hasTestSettingsNumber := testSettingsMap["test_settings_number"]
-				if testSettingsNumber != 0 {
-					if hasTestSettingsNumber && testSettingsNumber != testSettingsNumberInJSON {
-						return nil, cli.NewExitError("--test_settings_number and --setting have different number", 1)
-					}
-					setting = mergeTestSettingsNumberToSetting(testSettingsMap, hasTestSettings, testSettingsNumber)
-				}
-				isCrossBatchRunSetting = isCrossBatchRunSetting || hasTestSettings || hasTestSettingsNumber
-			}
-		}
-	}
-	if isCrossBatchRunSetting {
-		res, err := createBaseRequest(urlBase, apiToken, organization, project, httpHeadersMap).
-			SetHeader("Content-Type", "application/json").
-			SetBody(setting).
-			SetResult(BatchRun{}).
-			Post("/{organization}/{project}/cross-batch-run/")
-		if err != nil {
-			panic(err)
-		}
-		if exitErr := handleError(res); exitErr != nil {
-			return nil, exitErr
-		}
-		return res.Result().(*BatchRun), nil
-	} else { // normal batch run
-		res, err := createBaseRequest(urlBase, apiToken, organization, project, httpHeadersMap).
-			SetHeader("Content-Type", "application/json").
-			SetBody(setting).
-			SetResult(BatchRun{}).
-			Post("/{organization}/{project}/batch-run/")
-		if err != nil {
-			panic(err)
-		}
-		if exitErr := handleError(res); exitErr != nil {
-			return nil, exitErr
-		}
-		return res.Result().(*BatchRun), nil
-	}
+func StartBatchRun(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string, testSettingsNumber int, setting string) (*BatchRun, error) {
+	return newClient(urlBase, apiToken, organization, project, httpHeadersMap).StartBatchRun(testSettingsNumber, setting)
 }
 
 // GetBatchRun retrieves status and number of test cases executed of a specified batch run
-func GetBatchRun(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string, batchRunNumber int) (*BatchRun, *cli.ExitError) {
-	res, err := createBaseRequest(urlBase, apiToken, organization, project, httpHeadersMap).
-		SetPathParams(map[string]string{
-			"batch_run_number": strconv.Itoa(batchRunNumber),
-		}).
-		SetResult(BatchRun{}).
-		Get("/{organization}/{project}/batch-run/{batch_run_number}/")
-	if err != nil {
-		panic(err)
-	}
-	if exitErr := handleError(res); exitErr != nil {
-		return nil, exitErr
-	}
-	return res.Result().(*BatchRun), nil
+func GetBatchRun(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string, batchRunNumber int) (*BatchRun, error) {
+	return newClient(urlBase, apiToken, organization, project, httpHeadersMap).GetBatchRun(batchRunNumber)
 }
 
-func LatestBatchRunNo(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string) (int, *cli.ExitError) {
-	res, err := createBaseRequest(urlBase, apiToken, organization, project, httpHeadersMap).
-		SetQueryParam("count", "1").
-		SetResult(BatchRuns{}).
-		Get("/{organization}/{project}/batch-runs/")
-	if err != nil {
-		panic(err)
-	}
-	if exitErr := handleError(res); exitErr != nil {
-		return 0, exitErr
-	}
-	batchRuns := res.Result().(*BatchRuns).Batch_Runs
-	if len(batchRuns) == 0 {
-		return 0, cli.NewExitError("no batch run exists in this project", 1)
-	}
-	return batchRuns[0].Batch_Run_Number, nil
+// LatestBatchRunNo retrieves the number of the latest batch run in the project
+func LatestBatchRunNo(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string) (int, error) {
+	return newClient(urlBase, apiToken, organization, project, httpHeadersMap).LatestBatchRunNo()
 }
 
 // DeleteApp deletes app/ipa/apk file on the server
-func DeleteApp(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string, appFileNumber int) *cli.ExitError {
-	res, err := createBaseRequest(urlBase, apiToken, organization, project, httpHeadersMap).
-		SetBody(fmt.Sprintf("{\"app_file_number\":%d}", appFileNumber)).
-		Delete("/{organization}/{project}/delete-file/")
-	if err != nil {
-		panic(err)
-	}
-	if exitErr := handleError(res); exitErr != nil {
-		return exitErr
-	}
-	return nil
+func DeleteApp(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string, appFileNumber int) error {
+	return newClient(urlBase, apiToken, organization, project, httpHeadersMap).DeleteApp(appFileNumber)
 }
 
+// GetScreenshots downloads screenshots of a batch run as a zip file into downloadPath
 func GetScreenshots(urlBase string, apiToken string, organization string, project string, httpHeadersMap map[string]string, batchRunNumber int, downloadPath string, fileIndexType string, fileNameBodyType string, downloadType string, maskDynamicallyChangedArea bool) error {
-	var maskDynamicallyChangedAreaStr string
-	if maskDynamicallyChangedArea {
-		maskDynamicallyChangedAreaStr = "true"
-	} else {
-		maskDynamicallyChangedAreaStr = "false"
-	}
-	res, err := createBaseRequest(urlBase, apiToken, organization, project, httpHeadersMap).
-		SetPathParams(map[string]string{
-			"batch_run_number": strconv.Itoa(batchRunNumber),
-		}).
-		SetQueryParam("file_index_type", fileIndexType).
-		SetQueryParam("file_name_body_type", fileNameBodyType).
-		SetQueryParam("download_type", downloadType).
-		SetQueryParam("mask_dynamically_changed_area", maskDynamicallyChangedAreaStr).
-		SetOutput(downloadPath).
-		Get("/{organization}/{project}/batch-runs/{batch_run_number}/screenshots/")
-	if err != nil {
-		panic(err)
-	}
-	if res.StatusCode() != 200 {
-		// response body is included not in res but in downloadPath file,
-		responseText, err := ioutil.ReadFile(downloadPath)
-		if err != nil {
-			panic(err)
-		}
-		// remove downloadPath since it contains not zip contents but just error information
-		err = os.Remove(downloadPath)
-		if err != nil {
-			panic(err)
-		}
-		return cli.NewExitError(fmt.Sprintf("%s: %s", res.Status(), responseText), 1)
-	}
-	return nil
+	return newClient(urlBase, apiToken, organization, project, httpHeadersMap).GetScreenshots(batchRunNumber, downloadPath, fileIndexType, fileNameBodyType, downloadType, maskDynamicallyChangedArea)
 }
 
 func printMessage(printResult bool, format string, args ...interface{}) {
@@ -271,104 +161,6 @@ func printMessage(printResult bool, format string, args ...interface{}) {
 // ExecuteBatchRun starts batch run(s) and wait for its completion with showing progress
 func ExecuteBatchRun(urlBase string, apiToken string, organization string, project string,
 	httpHeadersMap map[string]string, testSettingsNumber int, setting string,
-	waitForResult bool, waitLimit int, printResult bool) (*BatchRun /*on which magic-pod bitrise step depends */, bool, bool, *cli.ExitError) {
-	// send batch run start request
-	batchRun, exitErr := StartBatchRun(urlBase, apiToken, organization, project, httpHeadersMap, testSettingsNumber, setting)
-	if exitErr != nil {
-		return nil, false, false, exitErr
-	}
-
-	crossBatchRunTotalTestCount := batchRun.Test_Cases.Total
-	printMessage(printResult, "test result page:\n")
-	printMessage(printResult, "%s\n", batchRun.Url)
-
-	// finish before the test finish
-	if !waitForResult {
-		return batchRun, false, false, nil
-	}
-
-	const initRetryInterval = 10 // retry more frequently at first
-	const retryInterval = 60
-	var limitSeconds int
-	if waitLimit == 0 {
-		limitSeconds = crossBatchRunTotalTestCount * 10 * 60 // wait up to test count x 10 minutes by default
-	} else {
-		limitSeconds = waitLimit
-	}
-	passedSeconds := 0
-	existsErr := false
-	existsUnresolved := false
-	printMessage(printResult, "\n#%d wait until %d tests to be finished.. \n", batchRun.Batch_Run_Number, batchRun.Test_Cases.Total)
-	prevFinished := 0
-	for {
-		batchRunUnderProgress, exitErr := GetBatchRun(urlBase, apiToken, organization, project, httpHeadersMap, batchRun.Batch_Run_Number)
-		if exitErr != nil {
-			if printResult {
-				fmt.Print(exitErr)
-			}
-			existsErr = true
-			break // give up the wait here
-		}
-		finished := batchRunUnderProgress.Test_Cases.Succeeded + batchRunUnderProgress.Test_Cases.Failed + batchRunUnderProgress.Test_Cases.Aborted + batchRunUnderProgress.Test_Cases.Unresolved
-		printMessage(printResult, ".") // show progress to prevent "long time no output" error on CircleCI etc
-		// output progress
-		if finished != prevFinished {
-			notSuccessfulCount := ""
-			if batchRunUnderProgress.Test_Cases.Failed > 0 {
-				notSuccessfulCount = fmt.Sprintf("%d failed", batchRunUnderProgress.Test_Cases.Failed)
-			}
-			if batchRunUnderProgress.Test_Cases.Unresolved > 0 {
-				if notSuccessfulCount != "" {
-					notSuccessfulCount += ", "
-				}
-				notSuccessfulCount += fmt.Sprintf("%d unresolved", batchRunUnderProgress.Test_Cases.Unresolved)
-			}
-			if notSuccessfulCount != "" {
-				notSuccessfulCount = fmt.Sprintf(" (%s)", notSuccessfulCount)
-			}
-			printMessage(printResult, "%d/%d finished%s\n", finished, batchRun.Test_Cases.Total, notSuccessfulCount)
-			prevFinished = finished
-		}
-		if batchRunUnderProgress.Status != "running" {
-			if batchRunUnderProgress.Test_Cases.Unresolved > 0 {
-				existsUnresolved = true
-			}
-			if batchRunUnderProgress.Status == "succeeded" {
-				printMessage(printResult, "batch run succeeded\n")
-				break
-			} else if batchRunUnderProgress.Status == "failed" {
-				if batchRunUnderProgress.Test_Cases.Failed > 0 {
-					unresolved := ""
-					if existsUnresolved {
-						unresolved = fmt.Sprintf(", %d unresolved", batchRunUnderProgress.Test_Cases.Unresolved)
-					}
-					printMessage(printResult, "batch run failed (%d failed%s)\n", batchRunUnderProgress.Test_Cases.Failed, unresolved)
-				} else {
-					printMessage(printResult, "batch run failed\n")
-				}
-				existsErr = true
-				break
-			} else if batchRunUnderProgress.Status == "unresolved" {
-				printMessage(printResult, "batch run unresolved (%d unresolved)\n", batchRunUnderProgress.Test_Cases.Unresolved)
-				break
-			} else if batchRunUnderProgress.Status == "aborted" {
-				printMessage(printResult, "batch run aborted\n")
-				existsErr = true
-				break
-			} else {
-				panic(batchRunUnderProgress.Status)
-			}
-		}
-		if passedSeconds > limitSeconds {
-			return batchRun, existsErr, existsUnresolved, cli.NewExitError("batch run never finished", 1)
-		}
-		if passedSeconds < 120 {
-			time.Sleep(initRetryInterval * time.Second)
-			passedSeconds += initRetryInterval
-		} else {
-			time.Sleep(retryInterval * time.Second)
-			passedSeconds += retryInterval
-		}
-	}
-	return batchRun, existsErr, existsUnresolved, nil
+	waitForResult bool, waitLimit int, printResult bool) (*BatchRun /*on which magic-pod bitrise step depends */, bool, bool, error) {
+	return newClient(urlBase, apiToken, organization, project, httpHeadersMap).ExecuteBatchRun(testSettingsNumber, setting, waitForResult, waitLimit, printResult)
 }
@@ -0,0 +1,250 @@
+package common
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // register the decoder for screenshots in JPEG
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// results of comparing a screenshot between two batch runs
+const (
+	ScreenshotSame      = "same"
+	ScreenshotDifferent = "different"
+	ScreenshotMissing   = "missing" // exists only in the base
+	ScreenshotAdded     = "added"   // exists only in the target
+)
+
+// CompareOption stands for how to compare screenshots
+type CompareOption struct {
+	// Threshold is the percentage of different pixels tolerated in each image
+	Threshold float64
+	// ColorTolerance is the difference of each color channel (0 - 255) regarded as the same pixel
+	ColorTolerance int
+	// DiffDir is the directory to write diff images into. No image is written if it is empty.
+	// Images left in it by the previous comparison are removed first
+	DiffDir string
+}
+
+// ScreenshotDiff stands for the result of comparing a screenshot between two batch runs
+type ScreenshotDiff struct {
+	Key              string      `json:"key"`
+	Status           string      `json:"status"`
+	Base             *Screenshot `json:"base,omitempty"`
+	Target           *Screenshot `json:"target,omitempty"`
+	Different_Pixels int         `json:"different_pixels"`
+	Total_Pixels     int         `json:"total_pixels"`
+	Diff_Percentage  float64     `json:"diff_percentage"`
+	Diff_Path        string      `json:"diff_path,omitempty"`
+}
+
+// Failed reports whether the screenshot differs beyond the threshold or exists only in one of the batch runs
+func (d *ScreenshotDiff) Failed() bool {
+	return d.Status != ScreenshotSame
+}
+
+func loadImage(imagePath string) (image.Image, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, &LocalIOError{Path: imagePath, Err: err}
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, &LocalIOError{Path: imagePath, Err: err}
+	}
+	return img, nil
+}
+
+func colorDistance(a color.Color, b color.Color) int {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	distance := 0
+	for _, pair := range [][2]uint32{{ar, br}, {ag, bg}, {ab, bb}, {aa, ba}} {
+		d := int(pair[0]>>8) - int(pair[1]>>8)
+		if d < 0 {
+			d = -d
+		}
+		if d > distance {
+			distance = d
+		}
+	}
+	return distance
+}
+
+// CompareImages counts pixels which differ more than colorTolerance, and returns an image
+// which shows the different pixels in red over the faded base image.
+// Pixels outside of the smaller image are regarded as different
+func CompareImages(basePath string, targetPath string, colorTolerance int) (differentPixels int, totalPixels int, diffImage image.Image, err error) {
+	base, err := loadImage(basePath)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	target, err := loadImage(targetPath)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	baseBounds := base.Bounds()
+	targetBounds := target.Bounds()
+	width := baseBounds.Dx()
+	if targetBounds.Dx() > width {
+		width = targetBounds.Dx()
+	}
+	height := baseBounds.Dy()
+	if targetBounds.Dy() > height {
+		height = targetBounds.Dy()
+	}
+	diff := image.NewNRGBA(image.Rect(0, 0, width, height))
+	red := color.NRGBA{R: 255, A: 255}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			basePoint := image.Pt(baseBounds.Min.X+x, baseBounds.Min.Y+y)
+			targetPoint := image.Pt(targetBounds.Min.X+x, targetBounds.Min.Y+y)
+			if !basePoint.In(baseBounds) || !targetPoint.In(targetBounds) {
+				differentPixels++
+				diff.Set(x, y, red)
+				continue
+			}
+			baseColor := base.At(basePoint.X, basePoint.Y)
+			if colorDistance(baseColor, target.At(targetPoint.X, targetPoint.Y)) > colorTolerance {
+				differentPixels++
+				diff.Set(x, y, red)
+				continue
+			}
+			gray := color.GrayModel.Convert(baseColor).(color.Gray).Y
+			faded := 255 - (255-gray)/4
+			diff.Set(x, y, color.NRGBA{R: faded, G: faded, B: faded, A: 255})
+		}
+	}
+	return differentPixels, width * height, diff, nil
+}
+
+func savePNG(img image.Image, imagePath string) error {
+	if err := os.MkdirAll(filepath.Dir(imagePath), 0755); err != nil {
+		return &LocalIOError{Path: filepath.Dir(imagePath), Err: err}
+	}
+	file, err := os.Create(imagePath)
+	if err != nil {
+		return &LocalIOError{Path: imagePath, Err: err}
+	}
+	err = png.Encode(file, img)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return &LocalIOError{Path: imagePath, Err: err}
+	}
+	return nil
+}
+
+// checkDuplicateKeys returns an error if screenshots in dir share a key, since they cannot be matched with the other batch run
+func checkDuplicateKeys(dir string, screenshots []Screenshot) error {
+	paths := make(map[string]string)
+	for _, screenshot := range screenshots {
+		key := screenshot.Key()
+		if duplicate, ok := paths[key]; ok {
+			return &ArgumentError{Message: fmt.Sprintf("%s and %s in %s have the same key %s", duplicate, screenshot.Archive_Path, dir, key)}
+		}
+		paths[key] = screenshot.Archive_Path
+	}
+	return nil
+}
+
+// cleanDiffDir removes the diff images written into dir by the previous comparison, so that they are not mixed with
+// the new ones. It fails without removing anything if dir contains other files than PNG images
+func cleanDiffDir(dir string) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !strings.EqualFold(filepath.Ext(path), ".png") {
+			return &ArgumentError{Message: fmt.Sprintf("%s is not a directory of diff images since it contains %s", dir, path)}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		if _, ok := err.(*ArgumentError); ok {
+			return err
+		}
+		return &LocalIOError{Path: dir, Err: err}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return &LocalIOError{Path: dir, Err: err}
+	}
+	return nil
+}
+
+// CompareScreenshots matches screenshots extracted into baseDir and targetDir by their keys, and compares each pair.
+// The results are sorted by the keys. It fails if the keys are not unique in baseDir or targetDir
+func CompareScreenshots(baseDir string, base []Screenshot, targetDir string, target []Screenshot, option CompareOption) ([]ScreenshotDiff, error) {
+	if err := checkDuplicateKeys(baseDir, base); err != nil {
+		return nil, err
+	}
+	if err := checkDuplicateKeys(targetDir, target); err != nil {
+		return nil, err
+	}
+	if option.DiffDir != "" {
+		if err := cleanDiffDir(option.DiffDir); err != nil {
+			return nil, err
+		}
+	}
+	diffs := make(map[string]*ScreenshotDiff)
+	for i := range base {
+		key := base[i].Key()
+		diffs[key] = &ScreenshotDiff{Key: key, Status: ScreenshotMissing, Base: &base[i]}
+	}
+	for i := range target {
+		key := target[i].Key()
+		if diff, ok := diffs[key]; ok {
+			diff.Target = &target[i]
+		} else {
+			diffs[key] = &ScreenshotDiff{Key: key, Status: ScreenshotAdded, Target: &target[i]}
+		}
+	}
+
+	keys := make([]string, 0, len(diffs))
+	for key := range diffs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	results := make([]ScreenshotDiff, 0, len(keys))
+	for _, key := range keys {
+		diff := diffs[key]
+		if diff.Base != nil && diff.Target != nil {
+			differentPixels, totalPixels, diffImage, err := CompareImages(
+				filepath.Join(baseDir, filepath.FromSlash(diff.Base.Path)),
+				filepath.Join(targetDir, filepath.FromSlash(diff.Target.Path)),
+				option.ColorTolerance)
+			if err != nil {
+				return nil, err
+			}
+			diff.Different_Pixels = differentPixels
+			diff.Total_Pixels = totalPixels
+			if totalPixels > 0 {
+				diff.Diff_Percentage = float64(differentPixels) * 100 / float64(totalPixels)
+			}
+			diff.Status = ScreenshotSame
+			if diff.Diff_Percentage > option.Threshold {
+				diff.Status = ScreenshotDifferent
+				if option.DiffDir != "" {
+					diffPath := filepath.Join(option.DiffDir, filepath.FromSlash(diff.Target.Path))
+					diffPath = diffPath[:len(diffPath)-len(filepath.Ext(diffPath))] + ".png"
+					if err := savePNG(diffImage, diffPath); err != nil {
+						return nil, err
+					}
+					diff.Diff_Path = diffPath
+				}
+			}
+		}
+		results = append(results, *diff)
+	}
+	return results, nil
+}
@@ -0,0 +1,189 @@
+package common
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeImage writes a width x height PNG image filled with fill, except the pixels in changed filled with change
+func writeImage(t *testing.T, imagePath string, width int, height int, fill color.Color, changed image.Rectangle, change color.Color) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if image.Pt(x, y).In(changed) {
+				img.Set(x, y, change)
+			} else {
+				img.Set(x, y, fill)
+			}
+		}
+	}
+	if err := savePNG(img, imagePath); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompareImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-compare-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	nearlyWhite := color.NRGBA{R: 250, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+	writeImage(t, filepath.Join(dir, "base.png"), 10, 10, white, image.Rectangle{}, nil)
+	writeImage(t, filepath.Join(dir, "same.png"), 10, 10, white, image.Rectangle{}, nil)
+	writeImage(t, filepath.Join(dir, "different.png"), 10, 10, white, image.Rect(0, 0, 2, 3), black)
+	writeImage(t, filepath.Join(dir, "nearly.png"), 10, 10, white, image.Rect(0, 0, 10, 1), nearlyWhite)
+	writeImage(t, filepath.Join(dir, "wide.png"), 12, 10, white, image.Rectangle{}, nil)
+	tests := []struct {
+		name                string
+		target              string
+		colorTolerance      int
+		wantDifferentPixels int
+		wantTotalPixels     int
+	}{
+		{name: "same", target: "same.png", wantTotalPixels: 100},
+		{name: "different", target: "different.png", wantDifferentPixels: 6, wantTotalPixels: 100},
+		{name: "different color within the tolerance", target: "nearly.png", colorTolerance: 5, wantTotalPixels: 100},
+		{name: "different color beyond the tolerance", target: "nearly.png", colorTolerance: 4, wantDifferentPixels: 10, wantTotalPixels: 100},
+		{name: "different size", target: "wide.png", wantDifferentPixels: 20, wantTotalPixels: 120},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			differentPixels, totalPixels, diffImage, err := CompareImages(filepath.Join(dir, "base.png"), filepath.Join(dir, test.target), test.colorTolerance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if differentPixels != test.wantDifferentPixels || totalPixels != test.wantTotalPixels {
+				t.Errorf("got %d / %d pixels, want %d / %d", differentPixels, totalPixels, test.wantDifferentPixels, test.wantTotalPixels)
+			}
+			if bounds := diffImage.Bounds(); bounds.Dx()*bounds.Dy() != test.wantTotalPixels {
+				t.Errorf("diff image is %s", bounds)
+			}
+			red := 0
+			for y := 0; y < diffImage.Bounds().Dy(); y++ {
+				for x := 0; x < diffImage.Bounds().Dx(); x++ {
+					if diffImage.At(x, y) == (color.NRGBA{R: 255, A: 255}) {
+						red++
+					}
+				}
+			}
+			if red != test.wantDifferentPixels {
+				t.Errorf("%d red pixels in the diff image, want %d", red, test.wantDifferentPixels)
+			}
+		})
+	}
+	_, _, _, err = CompareImages(filepath.Join(dir, "base.png"), filepath.Join(dir, "missing.png"), 0)
+	var localIOErr *LocalIOError
+	if !errors.As(err, &localIOErr) {
+		t.Errorf("err = %v, want *LocalIOError", err)
+	}
+}
+
+func TestCompareScreenshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-compare-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+	baseDir := filepath.Join(dir, "base")
+	targetDir := filepath.Join(dir, "target")
+	// the same screenshot may be written to another path, e.g. with another layout
+	screenshot := func(path string, lineNumber int) Screenshot {
+		return Screenshot{Path: path, Archive_Path: path, Setting: "iPhone 8", Test_Case_Number: 1, Line_Number: lineNumber}
+	}
+	base := []Screenshot{
+		screenshot("1/3.png", 3),
+		screenshot("1/5.png", 5),
+		screenshot("1/7.png", 7),
+	}
+	target := []Screenshot{
+		screenshot("iPhone 8/1/3.png", 3),
+		screenshot("iPhone 8/1/5.png", 5),
+		screenshot("iPhone 8/1/9.png", 9),
+	}
+	for _, s := range base {
+		writeImage(t, filepath.Join(baseDir, filepath.FromSlash(s.Path)), 10, 10, white, image.Rectangle{}, nil)
+	}
+	writeImage(t, filepath.Join(targetDir, "iPhone 8", "1", "3.png"), 10, 10, white, image.Rect(0, 0, 1, 1), black)
+	writeImage(t, filepath.Join(targetDir, "iPhone 8", "1", "5.png"), 10, 10, white, image.Rect(0, 0, 5, 2), black)
+	writeImage(t, filepath.Join(targetDir, "iPhone 8", "1", "9.png"), 10, 10, white, image.Rectangle{}, nil)
+
+	diffDir := filepath.Join(dir, "diff")
+	// an image of the previous comparison
+	staleImage := filepath.Join(diffDir, "iPhone 8", "1", "3.png")
+	writeImage(t, staleImage, 1, 1, white, image.Rectangle{}, nil)
+	diffs, err := CompareScreenshots(baseDir, base, targetDir, target, CompareOption{Threshold: 5, DiffDir: diffDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		key      string
+		status   string
+		pixels   int
+		diffPath string
+	}
+	var got []result
+	for _, diff := range diffs {
+		got = append(got, result{key: diff.Key, status: diff.Status, pixels: diff.Different_Pixels, diffPath: diff.Diff_Path})
+	}
+	want := []result{
+		{key: "iPhone 8/1/3/", status: ScreenshotSame, pixels: 1},
+		{key: "iPhone 8/1/5/", status: ScreenshotDifferent, pixels: 10, diffPath: filepath.Join(diffDir, "iPhone 8", "1", "5.png")},
+		{key: "iPhone 8/1/7/", status: ScreenshotMissing},
+		{key: "iPhone 8/1/9/", status: ScreenshotAdded},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if _, err := os.Stat(staleImage); !os.IsNotExist(err) {
+		t.Errorf("%s of the previous comparison is not removed", staleImage)
+	}
+	if _, err := os.Stat(want[1].diffPath); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompareScreenshotsKeepsOtherFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-compare-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	otherFile := filepath.Join(dir, "not-diff", "notes.txt")
+	if err := os.MkdirAll(filepath.Dir(otherFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(otherFile, []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		diffDir string
+	}{
+		{name: "directory containing other files", diffDir: filepath.Dir(otherFile)},
+		{name: "other file", diffDir: otherFile},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := CompareScreenshots(dir, nil, dir, nil, CompareOption{DiffDir: test.diffDir})
+			var argumentErr *ArgumentError
+			if !errors.As(err, &argumentErr) {
+				t.Errorf("err = %v, want *ArgumentError", err)
+			}
+		})
+	}
+	if _, err := os.Stat(otherFile); err != nil {
+		t.Errorf("%s is removed: %s", otherFile, err)
+	}
+}
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/go-resty/resty"
+)
+
+// partialDownload is saved next to the .part file so that a later download of the same request can resume it
+type partialDownload struct {
+	Request   string `json:"request"`
+	Validator string `json:"validator"`
+}
+
+var contentRangeStartPattern = regexp.MustCompile(`^bytes (\d+)-`)
+
+func readPartialDownload(metaPath string) partialDownload {
+	var meta partialDownload
+	content, err := ioutil.ReadFile(metaPath)
+	if err == nil {
+		json.Unmarshal(content, &meta)
+	}
+	return meta
+}
+
+// responseValidator returns a value for If-Range which identifies the content of the response
+func responseValidator(res *resty.Response) string {
+	if etag := res.Header().Get("ETag"); etag != "" {
+		return etag
+	}
+	return res.Header().Get("Last-Modified")
+}
+
+// downloadToFile downloads the response of GET url into downloadPath through downloadPath.part,
+// which is renamed to downloadPath when it is completed.
+// When the connection is lost, the download is resumed by a Range request if the server allows it.
+// The .part file is kept on failures, and resumed by a later call with the same requestKey
+// if the server returned ETag or Last-Modified. newRequest must return a new request every time
+func (c *Client) downloadToFile(ctx context.Context, newRequest func() *resty.Request, url string, downloadPath string, requestKey string) error {
+	partPath := downloadPath + ".part"
+	metaPath := partPath + ".json"
+	meta := readPartialDownload(metaPath)
+	if meta.Request != requestKey || meta.Validator == "" {
+		// the .part file is not for this request or cannot be verified
+		os.Remove(partPath)
+		os.Remove(metaPath)
+		meta = partialDownload{Request: requestKey}
+	}
+
+	policy := c.retryPolicy
+	for attempt := 1; ; attempt++ {
+		var offset int64
+		if stat, err := os.Stat(partPath); err == nil {
+			offset = stat.Size()
+		}
+		req := newRequest().SetDoNotParseResponse(true)
+		if offset > 0 {
+			req.SetHeader("Range", fmt.Sprintf("bytes=%d-", offset))
+			if meta.Validator != "" {
+				req.SetHeader("If-Range", meta.Validator)
+			}
+		}
+		res, err := c.execute(ctx, req, resty.MethodGet, url)
+		if err != nil {
+			return &TransportError{Err: err}
+		}
+		body := res.RawBody()
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		switch res.StatusCode() {
+		case http.StatusOK:
+			// the whole content
+		case http.StatusPartialContent:
+			match := contentRangeStartPattern.FindStringSubmatch(res.Header().Get("Content-Range"))
+			if match == nil || match[1] != strconv.FormatInt(offset, 10) {
+				body.Close()
+				os.Remove(partPath)
+				if attempt >= policy.MaxAttempts {
+					return &TransportError{Err: fmt.Errorf("unexpected Content-Range: %s", res.Header().Get("Content-Range"))}
+				}
+				continue
+			}
+			flag = os.O_WRONLY | os.O_APPEND
+		case http.StatusRequestedRangeNotSatisfiable:
+			// the .part file is longer than the content, so start over
+			body.Close()
+			os.Remove(partPath)
+			if attempt >= policy.MaxAttempts {
+				return &APIError{StatusCode: res.StatusCode(), Status: res.Status()}
+			}
+			continue
+		default:
+			responseText, _ := ioutil.ReadAll(io.LimitReader(body, 1<<20))
+			body.Close()
+			return &APIError{StatusCode: res.StatusCode(), Status: res.Status(), Body: string(responseText)}
+		}
+
+		meta.Validator = responseValidator(res)
+		if content, err := json.Marshal(meta); err == nil {
+			ioutil.WriteFile(metaPath, content, 0644)
+		}
+		file, err := os.OpenFile(partPath, flag, 0644)
+		if err != nil {
+			body.Close()
+			return &LocalIOError{Path: partPath, Err: err}
+		}
+		_, copyErr := io.Copy(file, body)
+		body.Close()
+		if err := file.Close(); err != nil {
+			return &LocalIOError{Path: partPath, Err: err}
+		}
+		if copyErr == nil {
+			break
+		}
+		canResume := res.StatusCode() == http.StatusPartialContent || res.Header().Get("Accept-Ranges") == "bytes"
+		if ctx.Err() != nil || !canResume || attempt >= policy.MaxAttempts {
+			if !canResume || meta.Validator == "" {
+				// a later call cannot resume it
+				os.Remove(partPath)
+				os.Remove(metaPath)
+			}
+			return &TransportError{Err: copyErr}
+		}
+		wait := policy.backoff(attempt)
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, wait, &TransportError{Err: copyErr})
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return &TransportError{Err: err}
+		}
+	}
+
+	if err := validateZip(partPath, downloadPath); err != nil {
+		// the content is broken, so it must not be resumed
+		os.Remove(partPath)
+		os.Remove(metaPath)
+		return err
+	}
+	if err := os.Rename(partPath, downloadPath); err != nil {
+		return &LocalIOError{Path: downloadPath, Err: err}
+	}
+	os.Remove(metaPath)
+	return nil
+}
+
+// validateZip checks the central directory of the zip file and the checksum of every entry,
+// which fail if the file is truncated. displayPath is used for errors
+func validateZip(zipPath string, displayPath string) error {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return &LocalIOError{Path: displayPath, Err: fmt.Errorf("downloaded file is not a valid zip file: %s", err)}
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		src, err := file.Open()
+		if err != nil {
+			return &LocalIOError{Path: displayPath, Err: fmt.Errorf("%s in the downloaded zip file is broken: %s", file.Name, err)}
+		}
+		_, err = io.Copy(ioutil.Discard, src)
+		src.Close()
+		if err != nil {
+			return &LocalIOError{Path: displayPath, Err: fmt.Errorf("%s in the downloaded zip file is broken: %s", file.Name, err)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package common
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newScreenshotZip returns a zip file which stores content without compression, so that it can be broken on purpose
+func newScreenshotZip(t *testing.T, content string) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	entry, err := writer.CreateHeader(&zip.FileHeader{Name: "iPhone 8/1/3.png", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// fakeDownloadServer serves content with etag, and loses the connection in the middle of the first cuts responses
+type fakeDownloadServer struct {
+	mutex        sync.Mutex
+	content      []byte
+	etag         string
+	acceptRanges bool
+	cuts         int
+	ranges       []string
+}
+
+func (s *fakeDownloadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	body := s.content
+	w.Header().Set("ETag", s.etag)
+	if s.acceptRanges {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Header.Get("Range") != "" && r.Header.Get("If-Range") == s.etag {
+			start, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.Header.Get("Range"), "bytes="), "-"))
+			body = s.content[start:]
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(s.content)-1, len(s.content)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(body)
+			return
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if s.cuts > 0 {
+		s.cuts--
+		// the connection is closed since the body is shorter than Content-Length
+		w.Write(body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		return
+	}
+	w.Write(body)
+}
+
+func TestGetScreenshotsResume(t *testing.T) {
+	content := newScreenshotZip(t, strings.Repeat("screenshot", 10000))
+	changedContent := newScreenshotZip(t, strings.Repeat("changed screenshot", 10000))
+	half := fmt.Sprintf("bytes=%d-", len(content)/2)
+	tests := []struct {
+		name         string
+		acceptRanges bool
+		cuts         int
+		maxAttempts  int
+		// calls is the number of GetScreenshots. Only the last one must succeed
+		calls int
+		// changed changes the content on the server before the last call
+		changed     bool
+		wantRanges  []string
+		wantContent []byte
+	}{
+		{
+			name:         "whole content",
+			acceptRanges: true,
+			maxAttempts:  1,
+			calls:        1,
+			wantRanges:   []string{""},
+			wantContent:  content,
+		},
+		{
+			name:         "resumed on retry",
+			acceptRanges: true,
+			cuts:         1,
+			maxAttempts:  3,
+			calls:        1,
+			wantRanges:   []string{"", half},
+			wantContent:  content,
+		},
+		{
+			name:         "resumed by a later call from the .part file",
+			acceptRanges: true,
+			cuts:         1,
+			maxAttempts:  1,
+			calls:        2,
+			wantRanges:   []string{"", half},
+			wantContent:  content,
+		},
+		{
+			name:         "content changed before the later call",
+			acceptRanges: true,
+			cuts:         1,
+			maxAttempts:  1,
+			calls:        2,
+			changed:      true,
+			wantRanges:   []string{"", half},
+			wantContent:  changedContent,
+		},
+		{
+			name:        "server without range requests",
+			cuts:        1,
+			maxAttempts: 3,
+			calls:       2,
+			wantRanges:  []string{"", ""},
+			wantContent: content,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "magic-pod-download-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			fake := &fakeDownloadServer{content: content, etag: `"1"`, acceptRanges: test.acceptRanges, cuts: test.cuts}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			client := newTestClient(server, WithRetryPolicy(RetryPolicy{MaxAttempts: test.maxAttempts, InitialBackoff: time.Millisecond}))
+			downloadPath := filepath.Join(dir, "screenshots.zip")
+
+			for call := 1; call <= test.calls; call++ {
+				if call == test.calls && test.changed {
+					fake.mutex.Lock()
+					fake.content = changedContent
+					fake.etag = `"2"`
+					fake.mutex.Unlock()
+				}
+				err := client.GetScreenshots(1, downloadPath, "line_number", "screenshot_name", "all", false)
+				if call < test.calls {
+					var transportErr *TransportError
+					if !errors.As(err, &transportErr) {
+						t.Fatalf("err = %v, want *TransportError", err)
+					}
+				} else if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(fake.ranges, test.wantRanges) {
+				t.Errorf("Range headers = %q, want %q", fake.ranges, test.wantRanges)
+			}
+			got, err := ioutil.ReadFile(downloadPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, test.wantContent) {
+				t.Errorf("downloaded %d bytes differ from the content of %d bytes", len(got), len(test.wantContent))
+			}
+			for _, path := range []string{downloadPath + ".part", downloadPath + ".part.json"} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s is left", path)
+				}
+			}
+		})
+	}
+}
+
+func TestGetScreenshotsBrokenZip(t *testing.T) {
+	content := newScreenshotZip(t, "screenshot")
+	corrupted := append([]byte{}, content...)
+	corrupted[bytes.Index(corrupted, []byte("screenshot"))] = 'S'
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "not a zip file", content: []byte("<html>maintenance</html>")},
+		{name: "truncated", content: content[:len(content)-10]},
+		{name: "checksum mismatch", content: corrupted},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "magic-pod-download-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			server := httptest.NewServer(&fakeDownloadServer{content: test.content, etag: `"1"`, acceptRanges: true})
+			defer server.Close()
+			downloadPath := filepath.Join(dir, "screenshots.zip")
+
+			err = newTestClient(server).GetScreenshots(1, downloadPath, "line_number", "screenshot_name", "all", false)
+			var localIOErr *LocalIOError
+			if !errors.As(err, &localIOErr) {
+				t.Fatalf("err = %v, want *LocalIOError", err)
+			}
+			// the broken content must not be resumed
+			for _, path := range []string{downloadPath, downloadPath + ".part", downloadPath + ".part.json"} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s is left", path)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoBatchRun is returned by LatestBatchRunNo when the project has no batch run
+var ErrNoBatchRun = errors.New("no batch run exists in this project")
+
+// TransportError is returned when a request could not be sent to the server
+// or its response could not be received
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("failed to communicate with the server: %s", e.Err)
+}
+
+// Unwrap returns the underlying network error
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// APIError is returned when the server responded with a non-successful status code
+type APIError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Status, e.Body)
+}
+
+// LocalIOError is returned when a local file or directory could not be read or written
+type LocalIOError struct {
+	Path string
+	Err  error
+}
+
+func (e *LocalIOError) Error() string {
+	if strings.Contains(e.Err.Error(), e.Path) { // e.g. *os.PathError already includes the path
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+// Unwrap returns the underlying filesystem error
+func (e *LocalIOError) Unwrap() error {
+	return e.Err
+}
+
+// SettingError is returned when a batch run setting cannot be parsed or conflicts with other arguments
+type SettingError struct {
+	// Path is the setting file, or empty if the setting is not read from a file
+	Path    string
+	Message string
+}
+
+func (e *SettingError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	}
+	return e.Message
+}
+
+// ArgumentError is returned when an argument like the path of an app file cannot be accepted
+type ArgumentError struct {
+	Message string
+}
+
+func (e *ArgumentError) Error() string {
+	return e.Message
+}
+
+// WaitTimeoutError is returned when a batch run did not finish within the wait limit.
+// The batch run may be still running on the server
+type WaitTimeoutError struct {
+	BatchRunNumber int
+	WaitLimit      time.Duration
+}
+
+func (e *WaitTimeoutError) Error() string {
+	return "batch run never finished"
+}
+
+// UnknownStatusError is returned when the server reported a batch run status which this client does not know
+type UnknownStatusError struct {
+	BatchRunNumber int
+	Status         string
+}
+
+func (e *UnknownStatusError) Error() string {
+	return fmt.Sprintf("unknown batch run status '%s'", e.Status)
+}
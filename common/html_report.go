@@ -0,0 +1,296 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// size of thumbnails embedded in the HTML report, twice as large as shown for high resolution displays
+const (
+	thumbnailWidth  = 240
+	thumbnailHeight = 480
+)
+
+type htmlReport struct {
+	BatchRun    *BatchRun
+	Statuses    []string
+	Groups      []htmlReportGroup
+	Others      []htmlReportImage
+	GeneratedAt string
+}
+
+type htmlReportGroup struct {
+	Name   string
+	Device string
+	Cases  []htmlReportCase
+}
+
+type htmlReportCase struct {
+	Number   int
+	Name     string
+	Status   string
+	Duration string
+	Url      string
+	Images   []htmlReportImage
+}
+
+type htmlReportImage struct {
+	Src       string
+	Thumbnail template.URL
+	Caption   string
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Magic Pod batch run #{{.BatchRun.Batch_Run_Number}}</title>
+<style>
+body { font-family: sans-serif; margin: 24px; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 16px; }
+th, td { border-bottom: 1px solid #ddd; padding: 6px 8px; text-align: left; vertical-align: top; }
+summary { font-size: 1.2em; font-weight: bold; margin: 16px 0 8px; cursor: pointer; }
+.status { display: inline-block; padding: 2px 8px; border-radius: 4px; color: #fff; background: #888; }
+.status-succeeded { background: #2e7d32; }
+.status-failed { background: #c62828; }
+.status-aborted { background: #6a1b9a; }
+.status-unresolved { background: #ef6c00; }
+.status-running { background: #1565c0; }
+.images { display: flex; flex-wrap: wrap; gap: 8px; }
+figure { margin: 0; text-align: center; font-size: 0.8em; }
+figure img { max-width: 120px; max-height: 240px; border: 1px solid #ccc; }
+#filter label { margin-right: 12px; }
+</style>
+</head>
+<body>
+<h1>Batch run #{{.BatchRun.Batch_Run_Number}} <span class="status status-{{.BatchRun.Status}}">{{.BatchRun.Status}}</span></h1>
+<p>
+{{with .BatchRun.Test_Cases}}{{.Succeeded}} succeeded, {{.Failed}} failed, {{.Aborted}} aborted, {{.Unresolved}} unresolved / {{.Total}} test cases{{end}}<br>
+<a href="{{.BatchRun.Url}}">{{.BatchRun.Url}}</a><br>
+generated at {{.GeneratedAt}}
+</p>
+<p id="filter">Show:
+{{range .Statuses}}<label><input type="checkbox" value="{{.}}" checked> {{.}}</label>{{end}}
+</p>
+{{range .Groups}}
+<details open>
+<summary>{{.Name}}{{if .Device}} ({{.Device}}){{end}}</summary>
+<table>
+<tr><th>No</th><th>Name</th><th>Status</th><th>Duration</th><th>Screenshots</th></tr>
+{{range .Cases}}
+<tr data-status="{{.Status}}">
+<td>{{.Number}}</td>
+<td>{{if .Url}}<a href="{{.Url}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</td>
+<td><span class="status status-{{.Status}}">{{.Status}}</span></td>
+<td>{{.Duration}}</td>
+<td><div class="images">{{range .Images}}<figure><a href="{{.Src}}"><img src="{{if .Thumbnail}}{{.Thumbnail}}{{else}}{{.Src}}{{end}}" alt="{{.Caption}}"></a><figcaption>{{.Caption}}</figcaption></figure>{{end}}</div></td>
+</tr>
+{{end}}
+</table>
+</details>
+{{end}}
+{{if .Others}}
+<details>
+<summary>Other screenshots</summary>
+<div class="images">{{range .Others}}<figure><a href="{{.Src}}"><img src="{{if .Thumbnail}}{{.Thumbnail}}{{else}}{{.Src}}{{end}}" alt="{{.Caption}}"></a><figcaption>{{.Caption}}</figcaption></figure>{{end}}</div>
+</details>
+{{end}}
+<script>
+document.querySelectorAll("#filter input").forEach(function (checkbox) {
+  checkbox.addEventListener("change", function () {
+    document.querySelectorAll("tr[data-status='" + checkbox.value + "']").forEach(function (row) {
+      row.style.display = checkbox.checked ? "" : "none";
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+// downscale shrinks img to fit in width x height by averaging the pixels. Smaller images are kept as they are
+func downscale(img image.Image, width int, height int) image.Image {
+	bounds := img.Bounds()
+	scale := 1.0
+	if bounds.Dx() > width {
+		scale = float64(width) / float64(bounds.Dx())
+	}
+	if bounds.Dy() > height && float64(height)/float64(bounds.Dy()) < scale {
+		scale = float64(height) / float64(bounds.Dy())
+	}
+	if scale == 1 {
+		return img
+	}
+	dstWidth, dstHeight := int(float64(bounds.Dx())*scale+0.5), int(float64(bounds.Dy())*scale+0.5)
+	if dstWidth < 1 {
+		dstWidth = 1
+	}
+	if dstHeight < 1 {
+		dstHeight = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	dstBounds := dst.Bounds()
+	for y := 0; y < dstBounds.Dy(); y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/dstBounds.Dy()
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/dstBounds.Dy()
+		for x := 0; x < dstBounds.Dx(); x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/dstBounds.Dx()
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/dstBounds.Dx()
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sr, sg, sb, sa := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+sr, g+sg, b+sb, a+sa, n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(b / n >> 8), A: uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// thumbnailURL returns a data URI of the downscaled image so that it is shown without the image file
+func thumbnailURL(imagePath string) (template.URL, error) {
+	img, err := loadImage(imagePath)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, downscale(img, thumbnailWidth, thumbnailHeight), &jpeg.Options{Quality: 80}); err != nil {
+		return "", &LocalIOError{Path: imagePath, Err: err}
+	}
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+func newHTMLReportImage(screenshot *Screenshot, reportDir string, screenshotDir string) htmlReportImage {
+	src := (&url.URL{Path: path.Join(screenshotDir, screenshot.Path)}).EscapedPath()
+	// the full-size image is still linked if it cannot be embedded
+	thumbnail, _ := thumbnailURL(filepath.Join(reportDir, filepath.FromSlash(screenshotDir), filepath.FromSlash(screenshot.Path)))
+	caption := screenshot.Screenshot_Name
+	if caption == "" {
+		switch {
+		case screenshot.Line_Number != 0:
+			caption = fmt.Sprintf("line %d", screenshot.Line_Number)
+		case screenshot.Index != 0:
+			caption = fmt.Sprintf("#%d", screenshot.Index)
+		default:
+			caption = path.Base(screenshot.Path)
+		}
+	}
+	return htmlReportImage{Src: src, Thumbnail: thumbnail, Caption: caption}
+}
+
+// screenshotDetailIndex returns the index of the test setting which took the screenshot, or -1 if it is unknown
+func screenshotDetailIndex(details []BatchRunDetail, screenshot *Screenshot) int {
+	if len(details) == 1 {
+		return 0
+	}
+	for i := range details {
+		name := details[i].Pattern_Name
+		if name != "" && (screenshot.Setting == name || path.Base(screenshot.Setting) == name) {
+			return i
+		}
+		if device := details[i].Device(); device != "" && screenshot.Setting == device {
+			return i
+		}
+	}
+	return -1
+}
+
+// WriteHTMLReport writes the summary and results of test cases of a batch run as an HTML page grouped by test setting.
+// screenshots are shown with each test case as thumbnails embedded in the page, and linked to the full-size images.
+// Their paths are relative to screenshotDir, which is relative to reportDir where the page is placed
+func WriteHTMLReport(batchRun *BatchRun, screenshots []Screenshot, reportDir string, screenshotDir string, w io.Writer) error {
+	details := batchRun.Test_Cases.Details
+	// details index -> test case number -> images
+	images := make([]map[int][]htmlReportImage, len(details))
+	for i := range images {
+		images[i] = make(map[int][]htmlReportImage)
+	}
+	sorted := append([]Screenshot{}, screenshots...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Line_Number+sorted[i].Index < sorted[j].Line_Number+sorted[j].Index
+	})
+	report := htmlReport{BatchRun: batchRun, GeneratedAt: time.Now().Format("2006-01-02 15:04:05 MST")}
+	for i := range sorted {
+		screenshot := &sorted[i]
+		image := newHTMLReportImage(screenshot, reportDir, screenshotDir)
+		detailIndex := screenshotDetailIndex(details, screenshot)
+		if detailIndex < 0 {
+			report.Others = append(report.Others, image)
+			continue
+		}
+		images[detailIndex][screenshot.Test_Case_Number] = append(images[detailIndex][screenshot.Test_Case_Number], image)
+	}
+
+	statuses := make(map[string]bool)
+	for i := range details {
+		detail := &details[i]
+		group := htmlReportGroup{Name: detail.Pattern_Name, Device: detail.Device()}
+		if group.Name == "" {
+			group.Name = fmt.Sprintf("batch run #%d", batchRun.Batch_Run_Number)
+		}
+		for j := range detail.Included_Test_Cases {
+			result := &detail.Included_Test_Cases[j]
+			testCase := htmlReportCase{
+				Number:   result.Number,
+				Name:     result.Name,
+				Status:   result.Status,
+				Duration: "-",
+				Url:      result.Url,
+				Images:   images[i][result.Number],
+			}
+			delete(images[i], result.Number)
+			if duration, ok := result.Duration(); ok {
+				testCase.Duration = duration.String()
+			}
+			if !statuses[result.Status] {
+				statuses[result.Status] = true
+				report.Statuses = append(report.Statuses, result.Status)
+			}
+			group.Cases = append(group.Cases, testCase)
+		}
+		report.Groups = append(report.Groups, group)
+	}
+	// screenshots of test cases not in the results
+	for i := range images {
+		numbers := make([]int, 0, len(images[i]))
+		for number := range images[i] {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		for _, number := range numbers {
+			report.Others = append(report.Others, images[i][number]...)
+		}
+	}
+	return htmlReportTemplate.Execute(w, &report)
+}
+
+// SaveHTMLReport writes the HTML report of a batch run into reportPath.
+// screenshotDir is the directory of the screenshots relative to reportPath
+func SaveHTMLReport(batchRun *BatchRun, screenshots []Screenshot, screenshotDir string, reportPath string) error {
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return &LocalIOError{Path: reportPath, Err: err}
+	}
+	if err := WriteHTMLReport(batchRun, screenshots, filepath.Dir(reportPath), screenshotDir, file); err != nil {
+		file.Close()
+		return &LocalIOError{Path: reportPath, Err: err}
+	}
+	if err := file.Close(); err != nil {
+		return &LocalIOError{Path: reportPath, Err: err}
+	}
+	return nil
+}
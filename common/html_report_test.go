@@ -0,0 +1,75 @@
+package common
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownscale(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		wantBounds    image.Rectangle
+	}{
+		{name: "portrait", width: 750, height: 1334, wantBounds: image.Rect(0, 0, 240, 427)},
+		{name: "landscape", width: 1334, height: 750, wantBounds: image.Rect(0, 0, 240, 135)},
+		{name: "tall", width: 100, height: 960, wantBounds: image.Rect(0, 0, 50, 480)},
+		{name: "small", width: 100, height: 200, wantBounds: image.Rect(0, 0, 100, 200)},
+		{name: "thin", width: 4800, height: 1, wantBounds: image.Rect(0, 0, 240, 1)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, test.width, test.height))
+			if got := downscale(img, thumbnailWidth, thumbnailHeight).Bounds(); got != test.wantBounds {
+				t.Errorf("bounds = %v, want %v", got, test.wantBounds)
+			}
+		})
+	}
+}
+
+func TestDownscaleAveragesPixels(t *testing.T) {
+	// black and white stripes become gray
+	img := image.NewGray(image.Rect(0, 0, 480, 2))
+	for x := 0; x < 480; x += 2 {
+		img.SetGray(x, 0, color.Gray{Y: 255})
+		img.SetGray(x, 1, color.Gray{Y: 255})
+	}
+	r, g, b, _ := downscale(img, 240, 480).At(0, 0).RGBA()
+	if r>>8 != 127 || g>>8 != 127 || b>>8 != 127 {
+		t.Errorf("color = (%d, %d, %d), want gray", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestWriteHTMLReportEmbedsThumbnails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-report-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := savePNG(image.NewRGBA(image.Rect(0, 0, 750, 1334)), filepath.Join(dir, "screenshots", "1_login", "3.png")); err != nil {
+		t.Fatal(err)
+	}
+	screenshots := []Screenshot{
+		{Path: "1_login/3.png", Archive_Path: "1_login/3.png", Test_Case_Number: 1, Test_Case_Name: "login", Line_Number: 3},
+		{Path: "1_login/4.png", Archive_Path: "1_login/4.png", Test_Case_Number: 1, Test_Case_Name: "login", Line_Number: 4},
+	}
+	batchRun := newTestBatchRun(withResults(BatchRunDetail{Model: "Pixel 4"}, "failed"))
+	var buf bytes.Buffer
+	if err := WriteHTMLReport(batchRun, screenshots, dir, "screenshots", &buf); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	if !strings.Contains(html, `<a href="screenshots/1_login/3.png"><img src="data:image/jpeg;base64,`) {
+		t.Error("the thumbnail is not embedded")
+	}
+	// the missing file is linked instead
+	if !strings.Contains(html, `<a href="screenshots/1_login/4.png"><img src="screenshots/1_login/4.png"`) {
+		t.Error("the image which cannot be embedded is not linked")
+	}
+}
@@ -0,0 +1,138 @@
+package common
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+)
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       float64          `xml:"time,attr"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitProblem `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Content string `xml:",chardata"`
+}
+
+func junitSuiteName(batchRun *BatchRun, detail *BatchRunDetail) string {
+	if detail.Pattern_Name != "" {
+		return detail.Pattern_Name
+	}
+	return fmt.Sprintf("batch run #%d", batchRun.Batch_Run_Number)
+}
+
+func newJUnitTestCase(suiteName string, result *TestCaseResult) junitTestCase {
+	testCase := junitTestCase{
+		Name:      fmt.Sprintf("#%d %s", result.Number, result.Name),
+		ClassName: suiteName,
+	}
+	if duration, ok := result.Duration(); ok {
+		testCase.Time = duration.Seconds()
+	}
+	resultPage := fmt.Sprintf("result page: %s", result.Url)
+	switch result.Status {
+	case "succeeded":
+	case "unresolved":
+		// the test passed but self-healing happened
+		testCase.SystemOut = fmt.Sprintf("unresolved (self-healing happened), %s", resultPage)
+	case "failed":
+		testCase.Failure = &junitProblem{Message: "test case failed", Type: result.Status, Content: resultPage}
+	case "aborted":
+		testCase.Error = &junitProblem{Message: "test case aborted", Type: result.Status, Content: resultPage}
+	default:
+		testCase.Skipped = &junitProblem{Message: fmt.Sprintf("test case %s", result.Status), Content: resultPage}
+	}
+	return testCase
+}
+
+// WriteJUnitReport writes results of test cases in a finished batch run as JUnit XML.
+// Each test setting of a cross batch run becomes a separate testsuite
+func WriteJUnitReport(batchRun *BatchRun, w io.Writer) error {
+	report := junitTestSuites{
+		Name: fmt.Sprintf("Magic Pod batch run #%d", batchRun.Batch_Run_Number),
+	}
+	for i := range batchRun.Test_Cases.Details {
+		detail := &batchRun.Test_Cases.Details[i]
+		suite := junitTestSuite{Name: junitSuiteName(batchRun, detail)}
+		for j := range detail.Included_Test_Cases {
+			result := &detail.Included_Test_Cases[j]
+			testCase := newJUnitTestCase(suite.Name, result)
+			if suite.Timestamp == "" {
+				suite.Timestamp = result.Started_At
+			}
+			suite.Tests++
+			suite.Time += testCase.Time
+			if testCase.Failure != nil {
+				suite.Failures++
+			} else if testCase.Error != nil {
+				suite.Errors++
+			} else if testCase.Skipped != nil {
+				suite.Skipped++
+			}
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Skipped += suite.Skipped
+		report.Time += suite.Time
+		report.TestSuites = append(report.TestSuites, suite)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// SaveJUnitReport writes the JUnit XML report of a finished batch run into reportPath
+func SaveJUnitReport(batchRun *BatchRun, reportPath string) error {
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return &LocalIOError{Path: reportPath, Err: err}
+	}
+	if err := WriteJUnitReport(batchRun, file); err != nil {
+		file.Close()
+		return &LocalIOError{Path: reportPath, Err: err}
+	}
+	if err := file.Close(); err != nil {
+		return &LocalIOError{Path: reportPath, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"time"
+)
+
+// PruneRule stands for a condition to choose uploaded files to delete.
+// Files matching NamePattern are deleted if they are older than OlderThan and are not among the KeepLast newest ones.
+// Zero values disable each condition
+type PruneRule struct {
+	OlderThan   time.Duration
+	KeepLast    int
+	NamePattern string
+}
+
+// Select returns files to delete among appFiles, newest first.
+// Files whose upload time is unknown are never deleted by OlderThan
+func (r *PruneRule) Select(appFiles []AppFile, now time.Time) ([]AppFile, error) {
+	if r.OlderThan <= 0 && r.KeepLast <= 0 {
+		return nil, &ArgumentError{Message: "either of older than or keep last must be specified"}
+	}
+	if r.NamePattern != "" {
+		if _, err := path.Match(r.NamePattern, ""); err != nil {
+			return nil, &ArgumentError{Message: fmt.Sprintf("invalid name pattern %q: %s", r.NamePattern, err)}
+		}
+	}
+
+	var candidates []AppFile
+	for _, appFile := range appFiles {
+		if r.NamePattern != "" {
+			if matched, _ := path.Match(r.NamePattern, appFile.File_Name); !matched {
+				continue
+			}
+		}
+		candidates = append(candidates, appFile)
+	}
+	// newest first. The file number decides the order when the upload time is the same or unknown
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ti, iok := candidates[i].UploadedAt()
+		tj, jok := candidates[j].UploadedAt()
+		if iok && jok && !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return candidates[i].File_No > candidates[j].File_No
+	})
+
+	selected := []AppFile{}
+	for i, appFile := range candidates {
+		if i < r.KeepLast {
+			continue
+		}
+		if r.OlderThan > 0 {
+			uploadedAt, ok := appFile.UploadedAt()
+			if !ok || now.Sub(uploadedAt) < r.OlderThan {
+				continue
+			}
+		}
+		selected = append(selected, appFile)
+	}
+	return selected, nil
+}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPruneRuleSelect(t *testing.T) {
+	now := time.Date(2020, 6, 30, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) string {
+		return now.Add(-time.Duration(days) * 24 * time.Hour).Format(time.RFC3339)
+	}
+	appFiles := []AppFile{
+		{File_No: 1, File_Name: "app-1.apk", Uploaded_At: daysAgo(30)},
+		{File_No: 2, File_Name: "app-2.ipa", Uploaded_At: daysAgo(20)},
+		{File_No: 3, File_Name: "app-3.apk", Uploaded_At: daysAgo(10)},
+		{File_No: 4, File_Name: "app-4.apk", Uploaded_At: ""},
+		{File_No: 5, File_Name: "app-5.apk", Uploaded_At: daysAgo(1)},
+		{File_No: 6, File_Name: "other.apk", Uploaded_At: daysAgo(40)},
+	}
+	tests := []struct {
+		name string
+		rule PruneRule
+		want []int
+	}{
+		{
+			name: "older than",
+			rule: PruneRule{OlderThan: 15 * 24 * time.Hour},
+			want: []int{2, 1, 6},
+		},
+		{
+			name: "keep last",
+			rule: PruneRule{KeepLast: 3},
+			// the file of unknown upload time is ordered by its number
+			want: []int{2, 1, 6},
+		},
+		{
+			name: "keep last with name pattern",
+			rule: PruneRule{KeepLast: 2, NamePattern: "app-*.apk"},
+			want: []int{3, 1},
+		},
+		{
+			name: "older than and keep last",
+			rule: PruneRule{OlderThan: 15 * 24 * time.Hour, KeepLast: 5},
+			want: []int{6},
+		},
+		{
+			name: "older than with name pattern",
+			rule: PruneRule{OlderThan: 5 * 24 * time.Hour, NamePattern: "*.apk"},
+			want: []int{3, 1, 6},
+		},
+		{
+			name: "unknown upload time is never old",
+			rule: PruneRule{OlderThan: time.Nanosecond, NamePattern: "app-4.apk"},
+			want: []int{},
+		},
+		{
+			name: "unknown upload time is deleted by keep last",
+			rule: PruneRule{KeepLast: 1, NamePattern: "app-[45].apk"},
+			want: []int{4},
+		},
+		{
+			name: "keep more than existing",
+			rule: PruneRule{KeepLast: 10},
+			want: []int{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selected, err := test.rule.Select(appFiles, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []int{}
+			for _, appFile := range selected {
+				got = append(got, appFile.File_No)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("selected %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPruneRuleSelectError(t *testing.T) {
+	tests := []struct {
+		name string
+		rule PruneRule
+	}{
+		{name: "no condition", rule: PruneRule{NamePattern: "*.apk"}},
+		{name: "invalid name pattern", rule: PruneRule{KeepLast: 1, NamePattern: "[app"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.rule.Select([]AppFile{{File_No: 1, File_Name: "app.apk"}}, time.Now())
+			var argumentErr *ArgumentError
+			if !errors.As(err, &argumentErr) {
+				t.Errorf("err = %v, want *ArgumentError", err)
+			}
+		})
+	}
+}
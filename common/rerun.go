@@ -0,0 +1,240 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RerunPlan stands for a cross batch run which executes only failed and unresolved test cases of a previous batch run
+type RerunPlan struct {
+	Original *BatchRun
+	// Setting is the cross batch run setting to be passed to StartBatchRunWithSetting
+	Setting *CrossBatchRunSetting
+	// DetailIndexes is the index of Original.Test_Cases.Details for each test setting in Setting
+	DetailIndexes []int
+}
+
+func needsRerun(status string) bool {
+	return status == "failed" || status == "unresolved"
+}
+
+// NewRerunPlan builds a setting which executes failed and unresolved test cases of batchRun with the same devices.
+// baseSetting is the setting in JSON format used for the original batch run, which can be empty.
+// Its keys like app_file_number are copied to each test setting, and if it has test_settings, each of them is copied
+// to the corresponding test setting. SettingError is returned if the length of test_settings differs from the original.
+// nil is returned if there is no test case to rerun
+func NewRerunPlan(batchRun *BatchRun, baseSetting string) (*RerunPlan, error) {
+	baseMap := make(map[string]interface{})
+	if baseSetting != "" {
+		if err := json.Unmarshal([]byte(baseSetting), &baseMap); err != nil {
+			return nil, &SettingError{Message: fmt.Sprintf("setting must be a JSON object: %s", err)}
+		}
+	}
+	details := batchRun.Test_Cases.Details
+	var baseTestSettings []interface{}
+	if testSettings, ok := baseMap["test_settings"]; ok {
+		baseTestSettings, ok = testSettings.([]interface{})
+		if !ok {
+			return nil, &SettingError{Message: "test_settings must be an array"}
+		}
+		if len(baseTestSettings) != len(details) {
+			return nil, &SettingError{Message: fmt.Sprintf("test_settings has %d elements, but batch run #%d has %d test settings",
+				len(baseTestSettings), batchRun.Batch_Run_Number, len(details))}
+		}
+	}
+
+	plan := &RerunPlan{Original: batchRun}
+	testSettings := []map[string]interface{}{}
+	for i, detail := range details {
+		testCaseNumbers := []int{}
+		for _, result := range detail.Included_Test_Cases {
+			if needsRerun(result.Status) {
+				testCaseNumbers = append(testCaseNumbers, result.Number)
+			}
+		}
+		if len(testCaseNumbers) == 0 {
+			continue
+		}
+		testSetting := make(map[string]interface{})
+		deviceSettings := map[string]string{
+			"environment": detail.Environment,
+			"os":          detail.Os,
+			"device_type": detail.Device_Type,
+			"version":     detail.Version,
+			"model":       detail.Model,
+		}
+		for k, v := range deviceSettings {
+			if v != "" {
+				testSetting[k] = v
+			}
+		}
+		for k, v := range baseMap {
+			if k != "test_settings" && k != "test_settings_number" && k != "concurrency" {
+				testSetting[k] = v
+			}
+		}
+		if baseTestSettings != nil {
+			if baseTestSetting, ok := baseTestSettings[i].(map[string]interface{}); ok {
+				for k, v := range baseTestSetting {
+					testSetting[k] = v
+				}
+			}
+		}
+		testSetting["test_case_numbers"] = testCaseNumbers
+		testSettings = append(testSettings, testSetting)
+		plan.DetailIndexes = append(plan.DetailIndexes, i)
+	}
+	if len(testSettings) == 0 {
+		return nil, nil
+	}
+
+	settingMap := map[string]interface{}{"test_settings": testSettings}
+	if concurrency, ok := baseMap["concurrency"]; ok {
+		settingMap["concurrency"] = concurrency
+	}
+	settingBytes, _ := json.Marshal(settingMap)
+	plan.Setting = &CrossBatchRunSetting{}
+	if err := json.Unmarshal(settingBytes, plan.Setting); err != nil {
+		return nil, &SettingError{Message: fmt.Sprintf("invalid setting: %s", err)}
+	}
+	return plan, nil
+}
+
+// UseTestSettingsNumber sets the test settings number used for the original batch run if every test setting is rerun,
+// and reports whether it is set. The server matches test_settings with the test settings of the number by their positions,
+// so it cannot be used when test settings without test cases to rerun are skipped
+func (p *RerunPlan) UseTestSettingsNumber(testSettingsNumber int) bool {
+	if len(p.DetailIndexes) != len(p.Original.Test_Cases.Details) {
+		return false
+	}
+	p.Setting.Test_Settings_Number = testSettingsNumber
+	return true
+}
+
+// sameDevice reports whether two details can be for the same device. Empty fields are regarded as unknown
+func sameDevice(a *BatchRunDetail, b *BatchRunDetail) bool {
+	pairs := [][2]string{
+		{a.Environment, b.Environment},
+		{a.Os, b.Os},
+		{a.Device_Type, b.Device_Type},
+		{a.Version, b.Version},
+		{a.Model, b.Model},
+	}
+	for _, pair := range pairs {
+		if pair[0] != "" && pair[1] != "" && pair[0] != pair[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// rerunIncludes reports whether all test cases of detail were requested by the j-th test setting of the rerun
+func (p *RerunPlan) rerunIncludes(j int, detail *BatchRunDetail) bool {
+	if p.Setting == nil || j >= len(p.Setting.Test_Settings) {
+		return false
+	}
+	requested := make(map[int]bool)
+	for _, number := range p.Setting.Test_Settings[j].Test_Case_Numbers {
+		requested[number] = true
+	}
+	for _, result := range detail.Included_Test_Cases {
+		if !requested[result.Number] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRerunDetails returns the index of Original.Test_Cases.Details for each detail of the rerun, or -1 if no detail matches.
+// The server does not guarantee that details are in the order of test settings, so they are matched by the device,
+// and then by the pattern name, the test cases and the order
+func (p *RerunPlan) matchRerunDetails(rerun *BatchRun) []int {
+	used := make([]bool, len(p.DetailIndexes))
+	matched := make([]int, len(rerun.Test_Cases.Details))
+	for k := range rerun.Test_Cases.Details {
+		rerunDetail := &rerun.Test_Cases.Details[k]
+		best, bestScore := -1, -1
+		for j, detailIndex := range p.DetailIndexes {
+			original := &p.Original.Test_Cases.Details[detailIndex]
+			if used[j] || !sameDevice(original, rerunDetail) {
+				continue
+			}
+			score := 0
+			if rerunDetail.Pattern_Name != "" && rerunDetail.Pattern_Name == original.Pattern_Name {
+				score += 4
+			}
+			if p.rerunIncludes(j, rerunDetail) {
+				score += 2
+			}
+			if j == k {
+				score++
+			}
+			if score > bestScore {
+				best, bestScore = j, score
+			}
+		}
+		matched[k] = -1
+		if best >= 0 {
+			used[best] = true
+			matched[k] = p.DetailIndexes[best]
+		}
+	}
+	return matched
+}
+
+// Merge combines the result of the rerun with the original batch run.
+// The latest result of each test case is used and the counts and the status are computed again.
+// Results of the rerun which match no original test setting are ignored
+func (p *RerunPlan) Merge(rerun *BatchRun) *BatchRun {
+	merged := *rerun
+	merged.Test_Cases.Details = make([]BatchRunDetail, len(p.Original.Test_Cases.Details))
+	for i, detail := range p.Original.Test_Cases.Details {
+		merged.Test_Cases.Details[i] = detail
+		merged.Test_Cases.Details[i].Included_Test_Cases = append([]TestCaseResult{}, detail.Included_Test_Cases...)
+	}
+	for k, detailIndex := range p.matchRerunDetails(rerun) {
+		if detailIndex < 0 {
+			continue
+		}
+		rerunDetail := rerun.Test_Cases.Details[k]
+		results := merged.Test_Cases.Details[detailIndex].Included_Test_Cases
+		for _, rerunResult := range rerunDetail.Included_Test_Cases {
+			for i := range results {
+				if results[i].Number == rerunResult.Number {
+					results[i] = rerunResult
+				}
+			}
+		}
+	}
+
+	merged.Test_Cases.Succeeded = 0
+	merged.Test_Cases.Failed = 0
+	merged.Test_Cases.Aborted = 0
+	merged.Test_Cases.Unresolved = 0
+	merged.Test_Cases.Total = 0
+	for _, detail := range merged.Test_Cases.Details {
+		for _, result := range detail.Included_Test_Cases {
+			merged.Test_Cases.Total++
+			switch result.Status {
+			case "succeeded":
+				merged.Test_Cases.Succeeded++
+			case "failed":
+				merged.Test_Cases.Failed++
+			case "aborted":
+				merged.Test_Cases.Aborted++
+			case "unresolved":
+				merged.Test_Cases.Unresolved++
+			}
+		}
+	}
+	if merged.Test_Cases.Failed > 0 {
+		merged.Status = "failed"
+	} else if merged.Test_Cases.Aborted > 0 {
+		merged.Status = "aborted"
+	} else if merged.Test_Cases.Unresolved > 0 {
+		merged.Status = "unresolved"
+	} else {
+		merged.Status = "succeeded"
+	}
+	return &merged
+}
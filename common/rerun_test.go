@@ -0,0 +1,262 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newTestBatchRun(details ...BatchRunDetail) *BatchRun {
+	batchRun := &BatchRun{Batch_Run_Number: 10, Status: "failed"}
+	batchRun.Test_Cases.Details = details
+	return batchRun
+}
+
+func results(statuses ...string) []TestCaseResult {
+	results := make([]TestCaseResult, len(statuses))
+	for i, status := range statuses {
+		results[i] = TestCaseResult{Number: i + 1, Status: status}
+	}
+	return results
+}
+
+func withResults(detail BatchRunDetail, statuses ...string) BatchRunDetail {
+	detail.Included_Test_Cases = results(statuses...)
+	return detail
+}
+
+func TestNewRerunPlan(t *testing.T) {
+	pixel := BatchRunDetail{Environment: "magic_pod", Os: "android", Device_Type: "emulator", Version: "11", Model: "Pixel 4"}
+	iphone := BatchRunDetail{Environment: "magic_pod", Os: "ios", Device_Type: "simulator", Version: "14.4", Model: "iPhone 8"}
+	tests := []struct {
+		name          string
+		details       []BatchRunDetail
+		baseSetting   string
+		want          string
+		detailIndexes []int
+	}{
+		{
+			name: "only failed and unresolved test cases",
+			details: []BatchRunDetail{
+				withResults(pixel, "succeeded", "failed", "aborted", "unresolved"),
+			},
+			want:          `{"test_settings":[{"environment":"magic_pod","os":"android","device_type":"emulator","version":"11","model":"Pixel 4","test_case_numbers":[2,4]}]}`,
+			detailIndexes: []int{0},
+		},
+		{
+			name: "test settings without failures are skipped",
+			details: []BatchRunDetail{
+				withResults(pixel, "succeeded"),
+				withResults(iphone, "succeeded", "failed"),
+			},
+			want:          `{"test_settings":[{"environment":"magic_pod","os":"ios","device_type":"simulator","version":"14.4","model":"iPhone 8","test_case_numbers":[2]}]}`,
+			detailIndexes: []int{1},
+		},
+		{
+			name: "keys of the base setting are copied",
+			details: []BatchRunDetail{
+				withResults(pixel, "failed"),
+			},
+			baseSetting:   `{"app_type":"app_file","app_file_number":3,"concurrency":2,"test_settings_number":1}`,
+			want:          `{"concurrency":2,"test_settings":[{"environment":"magic_pod","os":"android","device_type":"emulator","version":"11","model":"Pixel 4","app_type":"app_file","app_file_number":3,"test_case_numbers":[1]}]}`,
+			detailIndexes: []int{0},
+		},
+		{
+			name: "test settings of the base setting are copied to the corresponding ones",
+			details: []BatchRunDetail{
+				withResults(pixel, "failed"),
+				withResults(iphone, "failed"),
+			},
+			baseSetting:   `{"test_settings":[{"app_file_number":1},{"app_file_number":2}]}`,
+			want:          `{"test_settings":[{"environment":"magic_pod","os":"android","device_type":"emulator","version":"11","model":"Pixel 4","app_file_number":1,"test_case_numbers":[1]},{"environment":"magic_pod","os":"ios","device_type":"simulator","version":"14.4","model":"iPhone 8","app_file_number":2,"test_case_numbers":[1]}]}`,
+			detailIndexes: []int{0, 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := NewRerunPlan(newTestBatchRun(test.details...), test.baseSetting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(plan.Setting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameJSON(t, string(got), test.want)
+			if !reflect.DeepEqual(plan.DetailIndexes, test.detailIndexes) {
+				t.Errorf("DetailIndexes = %v, want %v", plan.DetailIndexes, test.detailIndexes)
+			}
+		})
+	}
+}
+
+func TestNewRerunPlanNothingToRerun(t *testing.T) {
+	plan, err := NewRerunPlan(newTestBatchRun(withResults(BatchRunDetail{Model: "Pixel 4"}, "succeeded", "aborted")), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan != nil {
+		t.Errorf("plan = %+v, want nil", plan)
+	}
+}
+
+func TestNewRerunPlanInvalidSetting(t *testing.T) {
+	tests := []struct {
+		name        string
+		baseSetting string
+	}{
+		{name: "not an object", baseSetting: "[1]"},
+		{name: "test settings is not an array", baseSetting: `{"test_settings":{"app_file_number":1}}`},
+		{name: "fewer test settings", baseSetting: `{"test_settings":[{"app_file_number":1}]}`},
+		{name: "more test settings", baseSetting: `{"test_settings":[{"app_file_number":1},{"app_file_number":2},{"app_file_number":3}]}`},
+	}
+	batchRun := newTestBatchRun(
+		withResults(BatchRunDetail{Model: "Pixel 4"}, "failed"),
+		withResults(BatchRunDetail{Model: "iPhone 8"}, "succeeded"),
+	)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewRerunPlan(batchRun, test.baseSetting)
+			var settingErr *SettingError
+			if !errors.As(err, &settingErr) {
+				t.Errorf("err = %v, want *SettingError", err)
+			}
+		})
+	}
+}
+
+func TestRerunPlanUseTestSettingsNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		details []BatchRunDetail
+		want    bool
+	}{
+		{
+			name:    "all test settings are rerun",
+			details: []BatchRunDetail{withResults(BatchRunDetail{Model: "Pixel 4"}, "failed"), withResults(BatchRunDetail{Model: "iPhone 8"}, "succeeded", "unresolved")},
+			want:    true,
+		},
+		{
+			name:    "test settings without failures are skipped",
+			details: []BatchRunDetail{withResults(BatchRunDetail{Model: "Pixel 4"}, "succeeded"), withResults(BatchRunDetail{Model: "iPhone 8"}, "failed")},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := NewRerunPlan(newTestBatchRun(test.details...), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := plan.UseTestSettingsNumber(3); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			wantNumber := 0
+			if test.want {
+				wantNumber = 3
+			}
+			if plan.Setting.Test_Settings_Number != wantNumber {
+				t.Errorf("Test_Settings_Number = %d, want %d", plan.Setting.Test_Settings_Number, wantNumber)
+			}
+		})
+	}
+}
+
+func TestRerunPlanMerge(t *testing.T) {
+	pixel := BatchRunDetail{Os: "android", Model: "Pixel 4"}
+	iphone := BatchRunDetail{Os: "ios", Model: "iPhone 8"}
+	ipad := BatchRunDetail{Os: "ios", Model: "iPad"}
+	original := newTestBatchRun(
+		withResults(pixel, "failed", "succeeded"),
+		withResults(ipad, "succeeded"),
+		withResults(iphone, "succeeded", "unresolved"),
+	)
+	tests := []struct {
+		name       string
+		rerun      []BatchRunDetail
+		wantStatus string
+		want       [][]string
+	}{
+		{
+			name: "details in the order of test settings",
+			rerun: []BatchRunDetail{
+				{Os: "android", Model: "Pixel 4", Included_Test_Cases: []TestCaseResult{{Number: 1, Status: "succeeded"}}},
+				{Os: "ios", Model: "iPhone 8", Included_Test_Cases: []TestCaseResult{{Number: 2, Status: "succeeded"}}},
+			},
+			wantStatus: "succeeded",
+			want:       [][]string{{"succeeded", "succeeded"}, {"succeeded"}, {"succeeded", "succeeded"}},
+		},
+		{
+			name: "details in a different order",
+			rerun: []BatchRunDetail{
+				{Os: "ios", Model: "iPhone 8", Included_Test_Cases: []TestCaseResult{{Number: 2, Status: "succeeded"}}},
+				{Os: "android", Model: "Pixel 4", Included_Test_Cases: []TestCaseResult{{Number: 1, Status: "failed"}}},
+			},
+			wantStatus: "failed",
+			want:       [][]string{{"failed", "succeeded"}, {"succeeded"}, {"succeeded", "succeeded"}},
+		},
+		{
+			name: "details without device are matched by test cases",
+			rerun: []BatchRunDetail{
+				{Included_Test_Cases: []TestCaseResult{{Number: 2, Status: "succeeded"}}},
+				{Included_Test_Cases: []TestCaseResult{{Number: 1, Status: "unresolved"}}},
+			},
+			wantStatus: "unresolved",
+			want:       [][]string{{"unresolved", "succeeded"}, {"succeeded"}, {"succeeded", "succeeded"}},
+		},
+		{
+			name: "details of other devices are ignored",
+			rerun: []BatchRunDetail{
+				{Os: "ios", Model: "iPad", Included_Test_Cases: []TestCaseResult{{Number: 1, Status: "succeeded"}}},
+			},
+			wantStatus: "failed",
+			want:       [][]string{{"failed", "succeeded"}, {"succeeded"}, {"succeeded", "unresolved"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := NewRerunPlan(original, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			merged := plan.Merge(newTestBatchRun(test.rerun...))
+			if merged.Status != test.wantStatus {
+				t.Errorf("Status = %s, want %s", merged.Status, test.wantStatus)
+			}
+			got := [][]string{}
+			total := 0
+			for _, detail := range merged.Test_Cases.Details {
+				statuses := []string{}
+				for _, result := range detail.Included_Test_Cases {
+					statuses = append(statuses, result.Status)
+				}
+				total += len(statuses)
+				got = append(got, statuses)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("statuses = %v, want %v", got, test.want)
+			}
+			if merged.Test_Cases.Total != total {
+				t.Errorf("Total = %d, want %d", merged.Test_Cases.Total, total)
+			}
+			// the original must not be changed
+			if original.Test_Cases.Details[0].Included_Test_Cases[0].Status != "failed" {
+				t.Error("the original batch run was changed")
+			}
+		})
+	}
+}
+
+func assertSameJSON(t *testing.T, got string, want string) {
+	t.Helper()
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal([]byte(got), &gotValue); err != nil {
+		t.Fatalf("%s: %s", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("%s: %s", want, err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty"
+)
+
+// RetryPolicy decides which failed requests are sent again and how long to wait before that
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one. 1 or less disables retry
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubled for each following retry
+	InitialBackoff time.Duration
+	// MaxBackoff is the upper limit of the wait computed from InitialBackoff, and of the wait requested by Retry-After
+	MaxBackoff time.Duration
+	// Jitter randomizes each wait by +-Jitter (0.0 - 1.0) of its length
+	Jitter float64
+	// RetryNonIdempotent allows retrying POST requests like upload-file or batch-run
+	// which can cause duplicate uploads or batch runs
+	RetryNonIdempotent bool
+	// OnRetry is called before waiting for each retry if not nil
+	OnRetry func(attempt int, wait time.Duration, cause error)
+}
+
+// DefaultRetryPolicy returns the policy used by NewClient unless WithRetryPolicy is specified
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     30 * time.Second,
+		Jitter:         0.2,
+	}
+}
+
+// WithRetryPolicy changes how transient failures like 502/503 or connection reset are retried
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retryPolicy = policy
+	}
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case resty.MethodGet, resty.MethodHead, resty.MethodPut, resty.MethodDelete, resty.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns the wait before the specified retry (1 origin)
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	wait := float64(p.InitialBackoff) * math.Exp2(float64(retry-1))
+	if p.MaxBackoff > 0 {
+		wait = math.Min(wait, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		wait *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(wait)
+}
+
+// retryAfter parses Retry-After header which is either seconds or HTTP date
+func retryAfter(res *resty.Response) (time.Duration, bool) {
+	value := res.Header().Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// execute sends the request, and sends it again according to the retry policy
+// when it failed because of a network error or a temporary server error
+func (c *Client) execute(ctx context.Context, req *resty.Request, method string, url string) (*resty.Response, error) {
+	policy := c.retryPolicy
+	canRetry := policy.RetryNonIdempotent || isIdempotentMethod(method)
+	for attempt := 1; ; attempt++ {
+		if body, ok := req.Body.(rewinder); ok && attempt > 1 {
+			if err := body.rewind(); err != nil {
+				return nil, err
+			}
+		}
+		res, err := req.Execute(method, url)
+		if attempt >= policy.MaxAttempts || !canRetry || ctx.Err() != nil {
+			return res, err
+		}
+		var cause error
+		wait := policy.backoff(attempt)
+		if err != nil {
+			cause = &TransportError{Err: err}
+		} else if isRetryableStatus(res.StatusCode()) {
+			cause = &APIError{StatusCode: res.StatusCode(), Status: res.Status(), Body: res.String()}
+			if retryAfterWait, ok := retryAfter(res); ok {
+				wait = retryAfterWait
+				if policy.MaxBackoff > 0 && wait > policy.MaxBackoff {
+					// e.g. Retry-After: 3600 must not block polling for an hour
+					wait = policy.MaxBackoff
+				}
+			}
+		} else {
+			return res, err
+		}
+		if res != nil && res.RawResponse != nil {
+			// the body is not read by resty if the request is for a raw body
+			res.RawResponse.Body.Close()
+		}
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, wait, cause)
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{retry: 1, want: time.Second},
+		{retry: 2, want: 2 * time.Second},
+		{retry: 3, want: 4 * time.Second},
+		{retry: 4, want: 5 * time.Second},
+	}
+	for _, test := range tests {
+		if got := policy.backoff(test.retry); got != test.want {
+			t.Errorf("backoff(%d) = %s, want %s", test.retry, got, test.want)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffJitter(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		if got := policy.backoff(1); got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("backoff(1) = %s, want 1s +-20%%", got)
+		}
+	}
+}
+
+func TestExecuteRetry(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		statuses      []int
+		retryAfter    string
+		nonIdempotent bool
+		wantAttempts  int
+		wantStatus    int
+		wantWaits     []time.Duration
+	}{
+		{
+			name:         "temporary error is retried",
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusOK},
+			wantAttempts: 2,
+			wantStatus:   http.StatusOK,
+			wantWaits:    []time.Duration{time.Millisecond},
+		},
+		{
+			name:         "up to max attempts",
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusBadGateway, http.StatusGatewayTimeout, http.StatusTooManyRequests, http.StatusOK},
+			wantAttempts: 3,
+			wantStatus:   http.StatusTooManyRequests,
+			wantWaits:    []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		},
+		{
+			name:         "other errors are not retried",
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusNotFound, http.StatusOK},
+			wantAttempts: 1,
+			wantStatus:   http.StatusNotFound,
+		},
+		{
+			name:         "POST is not retried by default",
+			method:       http.MethodPost,
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusOK},
+			wantAttempts: 1,
+			wantStatus:   http.StatusServiceUnavailable,
+		},
+		{
+			name:          "POST is retried if allowed",
+			method:        http.MethodPost,
+			statuses:      []int{http.StatusServiceUnavailable, http.StatusOK},
+			nonIdempotent: true,
+			wantAttempts:  2,
+			wantStatus:    http.StatusOK,
+			wantWaits:     []time.Duration{time.Millisecond},
+		},
+		{
+			name:         "Retry-After is honored",
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusOK},
+			retryAfter:   "0",
+			wantAttempts: 2,
+			wantStatus:   http.StatusOK,
+			wantWaits:    []time.Duration{0},
+		},
+		{
+			name:         "Retry-After is limited by max backoff",
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusOK},
+			retryAfter:   "3600",
+			wantAttempts: 2,
+			wantStatus:   http.StatusOK,
+			wantWaits:    []time.Duration{10 * time.Millisecond},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != test.method {
+					t.Errorf("method = %s, want %s", r.Method, test.method)
+				}
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.WriteHeader(test.statuses[attempts])
+				attempts++
+			}))
+			defer server.Close()
+			var waits []time.Duration
+			client := newTestClient(server, WithRetryPolicy(RetryPolicy{
+				MaxAttempts:        3,
+				InitialBackoff:     time.Millisecond,
+				MaxBackoff:         10 * time.Millisecond,
+				RetryNonIdempotent: test.nonIdempotent,
+				OnRetry: func(attempt int, wait time.Duration, cause error) {
+					waits = append(waits, wait)
+				},
+			}))
+
+			res, err := client.execute(context.Background(), client.createBaseRequest(context.Background()), test.method, "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode() != test.wantStatus {
+				t.Errorf("status = %d, want %d", res.StatusCode(), test.wantStatus)
+			}
+			if attempts != test.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, test.wantAttempts)
+			}
+			if !reflect.DeepEqual(waits, test.wantWaits) {
+				t.Errorf("waits = %v, want %v", waits, test.wantWaits)
+			}
+		})
+	}
+}
+
+func TestExecuteRetryStopsWhenCanceled(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	client := newTestClient(server, WithRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Hour,
+		OnRetry: func(attempt int, wait time.Duration, cause error) {
+			cancel()
+		},
+	}))
+
+	if _, err := client.execute(ctx, client.createBaseRequest(ctx), http.MethodGet, "/"); err != context.Canceled {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
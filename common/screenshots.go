@@ -0,0 +1,240 @@
+package common
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// layouts of files extracted by ExtractScreenshots
+const (
+	// ScreenshotLayoutAsIs keeps the directories in the zip file
+	ScreenshotLayoutAsIs = "as_is"
+	// ScreenshotLayoutTestCase groups files by test case, then by test setting
+	ScreenshotLayoutTestCase = "test_case"
+	// ScreenshotLayoutDevice groups files by test setting, then by test case
+	ScreenshotLayoutDevice = "device"
+)
+
+// Screenshot stands for an image in the zip file downloaded by GetScreenshots.
+// The directory of the image is regarded as its test case, and the directories above it as its test setting
+type Screenshot struct {
+	Path             string `json:"path"`
+	Archive_Path     string `json:"archive_path"`
+	Setting          string `json:"setting,omitempty"`
+	Test_Case_Number int    `json:"test_case_number,omitempty"`
+	Test_Case_Name   string `json:"test_case_name,omitempty"`
+	Line_Number      int    `json:"line_number,omitempty"`
+	Index            int    `json:"index,omitempty"`
+	Screenshot_Name  string `json:"screenshot_name,omitempty"`
+}
+
+// Key identifies the same screenshot among batch runs executed with the same test cases and test settings.
+// The test case is identified by its name if its directory has no number
+func (s *Screenshot) Key() string {
+	index := s.Line_Number
+	if index == 0 {
+		index = s.Index
+	}
+	testCase := strconv.Itoa(s.Test_Case_Number)
+	if s.Test_Case_Number == 0 {
+		testCase = s.Test_Case_Name
+	}
+	return fmt.Sprintf("%s/%s/%d/%s", s.Setting, testCase, index, s.Screenshot_Name)
+}
+
+// ScreenshotManifest stands for screenshots extracted from the zip file of a batch run
+type ScreenshotManifest struct {
+	Batch_Run_Number    int          `json:"batch_run_number"`
+	File_Index_Type     string       `json:"file_index_type"`
+	File_Name_Body_Type string       `json:"file_name_body_type"`
+	Screenshots         []Screenshot `json:"screenshots"`
+}
+
+var testCaseDirPattern = regexp.MustCompile(`^(\d+)[ _.\-]*(.*)$`)
+
+// isImageFile reports whether the file is a screenshot
+func isImageFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+// ParseScreenshotPath extracts the test case and the index and the name of a screenshot from its path in the zip file.
+// fileIndexType and fileNameBodyType must be the values passed to GetScreenshots. ok is false if it is not an image
+func ParseScreenshotPath(archivePath string, fileIndexType string, fileNameBodyType string) (screenshot *Screenshot, ok bool) {
+	archivePath = strings.Trim(strings.Replace(archivePath, "\\", "/", -1), "/")
+	if !isImageFile(archivePath) {
+		return nil, false
+	}
+	screenshot = &Screenshot{Path: archivePath, Archive_Path: archivePath}
+
+	// <setting>/.../<test case>/<index>[_<screenshot name>].png
+	dirs := strings.Split(path.Dir(archivePath), "/")
+	if dirs[0] == "." {
+		dirs = nil
+	}
+	if len(dirs) > 0 {
+		testCaseDir := dirs[len(dirs)-1]
+		if match := testCaseDirPattern.FindStringSubmatch(testCaseDir); match != nil {
+			screenshot.Test_Case_Number, _ = strconv.Atoi(match[1])
+			screenshot.Test_Case_Name = match[2]
+		} else {
+			screenshot.Test_Case_Name = testCaseDir
+		}
+		screenshot.Setting = strings.Join(dirs[:len(dirs)-1], "/")
+	}
+
+	body := strings.TrimSuffix(path.Base(archivePath), path.Ext(archivePath))
+	indexStr := body
+	if fileNameBodyType == "screenshot_name" {
+		if i := strings.Index(body, "_"); i >= 0 {
+			indexStr = body[:i]
+			screenshot.Screenshot_Name = body[i+1:]
+		}
+	}
+	if index, err := strconv.Atoi(indexStr); err == nil {
+		if fileIndexType == "auto_increment" {
+			screenshot.Index = index
+		} else {
+			screenshot.Line_Number = index
+		}
+	} else if fileNameBodyType == "screenshot_name" {
+		screenshot.Screenshot_Name = body
+	}
+	return screenshot, true
+}
+
+// layoutPath returns the path of the screenshot in the extracted directory
+func (s *Screenshot) layoutPath(layout string) string {
+	dirs := strings.Split(path.Dir(s.Archive_Path), "/")
+	if len(dirs) == 0 || dirs[0] == "." {
+		return s.Archive_Path
+	}
+	testCaseDir := dirs[len(dirs)-1]
+	fileName := path.Base(s.Archive_Path)
+	switch layout {
+	case ScreenshotLayoutTestCase:
+		return path.Join(testCaseDir, s.Setting, fileName)
+	case ScreenshotLayoutDevice:
+		return path.Join(s.Setting, testCaseDir, fileName)
+	default:
+		return s.Archive_Path
+	}
+}
+
+// safeJoin joins destDir and the path in a zip file, rejecting paths which point outside of destDir (zip slip)
+func safeJoin(destDir string, archivePath string) (string, error) {
+	archivePath = strings.Replace(archivePath, "\\", "/", -1)
+	// drive letters are checked also on the other platforms so that the same zip file is accepted everywhere
+	hasDriveLetter := len(archivePath) >= 2 && archivePath[1] == ':' &&
+		('a' <= archivePath[0] && archivePath[0] <= 'z' || 'A' <= archivePath[0] && archivePath[0] <= 'Z')
+	if path.IsAbs(archivePath) || filepath.IsAbs(archivePath) || filepath.VolumeName(archivePath) != "" || hasDriveLetter {
+		return "", fmt.Errorf("%s is an absolute path", archivePath)
+	}
+	for _, element := range strings.Split(archivePath, "/") {
+		if element == ".." {
+			return "", fmt.Errorf("%s points outside of the destination", archivePath)
+		}
+	}
+	joined := filepath.Join(destDir, filepath.FromSlash(archivePath))
+	rel, err := filepath.Rel(destDir, joined)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s points outside of the destination", archivePath)
+	}
+	return joined, nil
+}
+
+// ExtractScreenshots extracts the zip file downloaded by GetScreenshots into destDir with the layout,
+// and returns the screenshots in it. Entries which point outside of destDir or are symbolic links are rejected
+func ExtractScreenshots(zipPath string, destDir string, fileIndexType string, fileNameBodyType string, layout string) ([]Screenshot, error) {
+	switch layout {
+	case "":
+		layout = ScreenshotLayoutAsIs
+	case ScreenshotLayoutAsIs, ScreenshotLayoutTestCase, ScreenshotLayoutDevice:
+	default:
+		return nil, &ArgumentError{Message: fmt.Sprintf("layout must be '%s', '%s' or '%s'",
+			ScreenshotLayoutAsIs, ScreenshotLayoutTestCase, ScreenshotLayoutDevice)}
+	}
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, &LocalIOError{Path: zipPath, Err: err}
+	}
+	defer reader.Close()
+
+	// check all entries before extracting any of them, including the original paths even if the layout changes them
+	for _, file := range reader.File {
+		if file.Mode()&os.ModeSymlink != 0 {
+			return nil, &LocalIOError{Path: zipPath, Err: fmt.Errorf("%s is a symbolic link", file.Name)}
+		}
+		if _, err := safeJoin(destDir, file.Name); err != nil {
+			return nil, &LocalIOError{Path: zipPath, Err: err}
+		}
+	}
+	screenshots := []Screenshot{}
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		relPath := file.Name
+		screenshot, ok := ParseScreenshotPath(file.Name, fileIndexType, fileNameBodyType)
+		if ok {
+			relPath = screenshot.layoutPath(layout)
+			screenshot.Path = relPath
+			screenshots = append(screenshots, *screenshot)
+		}
+		destPath, err := safeJoin(destDir, relPath)
+		if err != nil {
+			return nil, &LocalIOError{Path: zipPath, Err: err}
+		}
+		if err := extractZipFile(file, destPath); err != nil {
+			return nil, err
+		}
+	}
+	return screenshots, nil
+}
+
+func extractZipFile(file *zip.File, destPath string) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return &LocalIOError{Path: filepath.Dir(destPath), Err: err}
+	}
+	src, err := file.Open()
+	if err != nil {
+		return &LocalIOError{Path: file.Name, Err: err}
+	}
+	defer src.Close()
+	dest, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return &LocalIOError{Path: destPath, Err: err}
+	}
+	_, err = io.Copy(dest, src)
+	if closeErr := dest.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return &LocalIOError{Path: destPath, Err: err}
+	}
+	return nil
+}
+
+// SaveScreenshotManifest writes the manifest in JSON format
+func SaveScreenshotManifest(manifest *ScreenshotManifest, manifestPath string) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(manifestPath, append(content, '\n'), 0644); err != nil {
+		return &LocalIOError{Path: manifestPath, Err: err}
+	}
+	return nil
+}
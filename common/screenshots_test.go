@@ -0,0 +1,102 @@
+package common
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	destDir := filepath.Join("out", "screenshots")
+	tests := []struct {
+		name        string
+		archivePath string
+		want        string
+	}{
+		{name: "file", archivePath: "1.png", want: filepath.Join(destDir, "1.png")},
+		{name: "nested file", archivePath: "iPhone 8/1_login/3.png", want: filepath.Join(destDir, "iPhone 8", "1_login", "3.png")},
+		{name: "backslashes", archivePath: `iPhone 8\1_login\3.png`, want: filepath.Join(destDir, "iPhone 8", "1_login", "3.png")},
+		{name: "dots in names", archivePath: "..foo/a..png", want: filepath.Join(destDir, "..foo", "a..png")},
+		{name: "current directory", archivePath: "./iPhone 8/./3.png", want: filepath.Join(destDir, "iPhone 8", "3.png")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := safeJoin(destDir, test.archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSafeJoinError(t *testing.T) {
+	tests := []struct {
+		name        string
+		archivePath string
+	}{
+		{name: "parent", archivePath: "../1.png"},
+		{name: "parent in the middle", archivePath: "iPhone 8/../../1.png"},
+		{name: "parent going back inside", archivePath: "iPhone 8/../1.png"},
+		{name: "only parent", archivePath: ".."},
+		{name: "parent with backslashes", archivePath: `..\1.png`},
+		{name: "parent in the middle with backslashes", archivePath: `iPhone 8\..\..\1.png`},
+		{name: "absolute", archivePath: "/etc/passwd"},
+		{name: "absolute with backslashes", archivePath: `\etc\passwd`},
+		{name: "drive letter", archivePath: `C:\Windows\1.png`},
+		{name: "drive letter with slashes", archivePath: "C:/Windows/1.png"},
+		{name: "UNC path", archivePath: `\\server\share\1.png`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, err := safeJoin(filepath.Join("out", "screenshots"), test.archivePath); err == nil {
+				t.Errorf("got %s, want an error", got)
+			}
+		})
+	}
+}
+
+func TestScreenshotKey(t *testing.T) {
+	key := func(archivePath string) string {
+		screenshot, ok := ParseScreenshotPath(archivePath, "line_number", "none")
+		if !ok {
+			t.Fatalf("%s is not a screenshot", archivePath)
+		}
+		return screenshot.Key()
+	}
+	tests := []struct {
+		name  string
+		path1 string
+		path2 string
+		same  bool
+	}{
+		{name: "numbered test cases with different names", path1: "iPhone 8/1_login/3.png", path2: "iPhone 8/1_sign in/3.png", same: true},
+		{name: "numbered test cases", path1: "iPhone 8/1_login/3.png", path2: "iPhone 8/2_login/3.png"},
+		{name: "test cases without numbers", path1: "iPhone 8/login/3.png", path2: "iPhone 8/logout/3.png"},
+		{name: "settings", path1: "iPhone 8/login/3.png", path2: "iPhone X/login/3.png"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key1, key2 := key(test.path1), key(test.path2)
+			if (key1 == key2) != test.same {
+				t.Errorf("keys %s and %s, want same = %v", key1, key2, test.same)
+			}
+		})
+	}
+}
+
+func TestCompareScreenshotsDuplicateKeys(t *testing.T) {
+	screenshots := []Screenshot{
+		{Path: "iPhone 8/1_login/3.png", Archive_Path: "iPhone 8/1_login/3.png", Setting: "iPhone 8", Test_Case_Number: 1, Test_Case_Name: "login", Line_Number: 3},
+		{Path: "iPhone 8/1-login/3.png", Archive_Path: "iPhone 8/1-login/3.png", Setting: "iPhone 8", Test_Case_Number: 1, Test_Case_Name: "login", Line_Number: 3},
+	}
+	var argumentErr *ArgumentError
+	if _, err := CompareScreenshots("base", screenshots, "target", nil, CompareOption{}); !errors.As(err, &argumentErr) {
+		t.Errorf("err = %v, want *ArgumentError for the base", err)
+	}
+	if _, err := CompareScreenshots("base", nil, "target", screenshots, CompareOption{}); !errors.As(err, &argumentErr) {
+		t.Errorf("err = %v, want *ArgumentError for the target", err)
+	}
+}
@@ -0,0 +1,156 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var settingVariablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandSettingVariables replaces ${NAME} in the setting in JSON format by vars["NAME"], or by the environment variable NAME
+// if vars does not have it. It is a textual replacement, so that it can be used also for numbers like
+// "app_file_number": ${FILE_NO}. The values are escaped as JSON strings, so that " or \ in them are kept in "${NAME}"
+func ExpandSettingVariables(setting string, vars map[string]string) (string, error) {
+	return expandSettingVariables(setting, vars, escapeJSONString)
+}
+
+// escapeJSONString escapes value to be put between double quotes in JSON
+func escapeJSONString(value string) string {
+	var buf strings.Builder
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	quoted := strings.TrimSuffix(buf.String(), "\n")
+	return quoted[1 : len(quoted)-1]
+}
+
+func expandSettingVariables(setting string, vars map[string]string, escape func(string) string) (string, error) {
+	var undefined []string
+	expanded := settingVariablePattern.ReplaceAllStringFunc(setting, func(match string) string {
+		name := settingVariablePattern.FindStringSubmatch(match)[1]
+		if value, ok := vars[name]; ok {
+			return escape(value)
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			return escape(value)
+		}
+		undefined = append(undefined, name)
+		return match
+	})
+	if len(undefined) > 0 {
+		return "", &SettingError{Message: fmt.Sprintf("variable %s is not defined by --var nor environment variables", strings.Join(undefined, ", "))}
+	}
+	return expanded, nil
+}
+
+// convertYAMLValue converts maps decoded by yaml into map[string]interface{} so that they can be encoded in JSON
+func convertYAMLValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{})
+		for key, elem := range v {
+			converted[fmt.Sprint(key)] = convertYAMLValue(elem)
+		}
+		return converted
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = convertYAMLValue(elem)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+// SettingToJSON encodes a setting decoded from YAML or JSON into a JSON string for StartBatchRun
+func SettingToJSON(setting interface{}) (string, error) {
+	settingBytes, err := json.Marshal(convertYAMLValue(setting))
+	if err != nil {
+		return "", err
+	}
+	return string(settingBytes), nil
+}
+
+// yamlVersions reads version keys as they are written, since yaml decodes "version: 14.0" as the number 14
+type yamlVersions struct {
+	Version       *string        `yaml:"version"`
+	Test_Settings []yamlVersions `yaml:"test_settings"`
+}
+
+// DecodeYAMLSetting decodes a batch run setting by unmarshal of yaml.Unmarshaler, keeping versions like 14.0 as written
+func DecodeYAMLSetting(unmarshal func(interface{}) error) (interface{}, error) {
+	var setting interface{}
+	if err := unmarshal(&setting); err != nil {
+		return nil, err
+	}
+	var versions yamlVersions
+	// an error means some keys have unexpected types or are unknown to yamlVersions, and the others are still decoded
+	unmarshal(&versions)
+	restoreYAMLVersions(setting, versions)
+	return setting, nil
+}
+
+// restoreYAMLVersions replaces numeric versions in the setting by their text in versions
+func restoreYAMLVersions(setting interface{}, versions yamlVersions) {
+	restore := func(value interface{}, versions yamlVersions) {
+		object, ok := value.(map[interface{}]interface{})
+		if !ok || versions.Version == nil {
+			return
+		}
+		switch object["version"].(type) {
+		case int, float64:
+			object["version"] = *versions.Version
+		}
+	}
+	restore(setting, versions)
+	if object, ok := setting.(map[interface{}]interface{}); ok {
+		if testSettings, ok := object["test_settings"].([]interface{}); ok && len(testSettings) == len(versions.Test_Settings) {
+			for i := range testSettings {
+				restore(testSettings[i], versions.Test_Settings[i])
+			}
+		}
+	}
+}
+
+// LoadSettingFile reads a batch run setting from a JSON or YAML file, expands variables in it
+// like ExpandSettingVariables and returns it in JSON format. Values are not escaped in YAML files
+func LoadSettingFile(path string, vars map[string]string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", &LocalIOError{Path: path, Err: err}
+	}
+	isJSON := strings.ToLower(filepath.Ext(path)) == ".json"
+	escape := func(value string) string { return value }
+	if isJSON {
+		escape = escapeJSONString
+	}
+	expanded, err := expandSettingVariables(string(content), vars, escape)
+	if err != nil {
+		return "", err
+	}
+	var setting interface{}
+	if isJSON {
+		// keep numbers as written, e.g. "version": 14.0
+		decoder := json.NewDecoder(strings.NewReader(expanded))
+		decoder.UseNumber()
+		err = decoder.Decode(&setting)
+	} else {
+		setting, err = DecodeYAMLSetting(func(v interface{}) error {
+			return yaml.Unmarshal([]byte(expanded), v)
+		})
+	}
+	if err != nil {
+		return "", &SettingError{Path: path, Message: err.Error()}
+	}
+	if _, ok := convertYAMLValue(setting).(map[string]interface{}); !ok {
+		return "", &SettingError{Path: path, Message: "setting must be an object"}
+	}
+	return SettingToJSON(setting)
+}
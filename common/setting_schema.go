@@ -0,0 +1,280 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type settingValueType int
+
+const (
+	settingString settingValueType = iota
+	settingInteger
+	settingIntegerOrNumericString // e.g. "app_file_number":"${FILE_NO}" in shell scripts
+	settingStringOrNumber         // e.g. version: 14.0 in YAML
+	settingIntegerArray
+	settingTestSettingArray
+)
+
+type settingProperty struct {
+	valueType settingValueType
+	enum      []string
+}
+
+// testSettingSchema is the keys available for a batch run, or each element of test_settings of a cross batch run
+var testSettingSchema = map[string]settingProperty{
+	"environment":       {valueType: settingString},
+	"os":                {valueType: settingString, enum: []string{"ios", "android", "browser"}},
+	"device_type":       {valueType: settingString, enum: []string{"simulator", "emulator", "real_device"}},
+	"version":           {valueType: settingStringOrNumber},
+	"model":             {valueType: settingString},
+	"app_type":          {valueType: settingString, enum: []string{"app_file", "app_url"}},
+	"app_url":           {valueType: settingString},
+	"app_file_number":   {valueType: settingIntegerOrNumericString},
+	"bundle_id":         {valueType: settingString},
+	"app_package":       {valueType: settingString},
+	"app_activity":      {valueType: settingString},
+	"browser":           {valueType: settingString},
+	"test_case_numbers": {valueType: settingIntegerArray},
+}
+
+// crossBatchRunSchema is the keys available only at the top level of a cross batch run
+var crossBatchRunSchema = map[string]settingProperty{
+	"test_settings_number": {valueType: settingInteger},
+	"test_settings":        {valueType: settingTestSettingArray},
+	"concurrency":          {valueType: settingInteger},
+}
+
+// SettingProblem stands for a problem found at Path (e.g. test_settings[1].model) of a setting
+type SettingProblem struct {
+	Path    string
+	Message string
+}
+
+// SettingValidationError is returned when a batch run setting does not follow the schema
+type SettingValidationError struct {
+	Problems []SettingProblem
+}
+
+func (e *SettingValidationError) Error() string {
+	lines := []string{"invalid setting:"}
+	for _, problem := range e.Problems {
+		if problem.Path == "" {
+			lines = append(lines, "  "+problem.Message)
+		} else {
+			lines = append(lines, fmt.Sprintf("  %s: %s", problem.Path, problem.Message))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ValidateSetting checks a batch run or cross batch run setting in JSON format before sending it to the server.
+// *SettingValidationError is returned if it is not a JSON object, or a known key has a wrong type or value.
+// Unknown keys are returned as warnings instead, since the server may accept keys which the schema does not know yet
+func ValidateSetting(setting string) (warnings []SettingProblem, err error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(setting), &value); err != nil {
+		message := err.Error()
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			line, column := lineAndColumn(setting, syntaxErr.Offset)
+			message = fmt.Sprintf("invalid JSON at line %d, column %d: %s", line, column, err)
+		}
+		return nil, &SettingValidationError{Problems: []SettingProblem{{Message: message}}}
+	}
+	settingMap, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, &SettingValidationError{Problems: []SettingProblem{{Message: "setting must be a JSON object"}}}
+	}
+	var problems []SettingProblem
+	validateObject(settingMap, "", true, &problems, &warnings)
+	if len(problems) > 0 {
+		return warnings, &SettingValidationError{Problems: problems}
+	}
+	return warnings, nil
+}
+
+func lineAndColumn(text string, offset int64) (int, int) {
+	line, column := 1, 1
+	for i, r := range text {
+		if int64(i) >= offset-1 {
+			break
+		}
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
+
+func joinPath(parent string, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+func validateObject(object map[string]interface{}, path string, topLevel bool, problems *[]SettingProblem, warnings *[]SettingProblem) {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		property, ok := testSettingSchema[key]
+		if !ok && topLevel {
+			property, ok = crossBatchRunSchema[key]
+		}
+		if !ok {
+			message := fmt.Sprintf("unknown key \"%s\"", key)
+			if suggestion := suggestKey(key, topLevel); suggestion != "" {
+				message += fmt.Sprintf(" (did you mean \"%s\"?)", suggestion)
+			}
+			*warnings = append(*warnings, SettingProblem{Path: joinPath(path, key), Message: message})
+			continue
+		}
+		validateValue(object[key], property, joinPath(path, key), problems, warnings)
+	}
+}
+
+func isInteger(value interface{}) bool {
+	number, ok := value.(float64)
+	return ok && number == math.Trunc(number)
+}
+
+func validateValue(value interface{}, property settingProperty, path string, problems *[]SettingProblem, warnings *[]SettingProblem) {
+	addProblem := func(format string, args ...interface{}) {
+		*problems = append(*problems, SettingProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	switch property.valueType {
+	case settingString:
+		str, ok := value.(string)
+		if !ok {
+			addProblem("must be a string")
+			return
+		}
+		if len(property.enum) > 0 && !containsString(property.enum, str) {
+			addProblem("\"%s\" is not one of %s", str, strings.Join(property.enum, ", "))
+		}
+	case settingStringOrNumber:
+		if _, ok := value.(string); !ok {
+			if _, ok := value.(float64); !ok {
+				addProblem("must be a string")
+			}
+		}
+	case settingInteger:
+		if !isInteger(value) {
+			addProblem("must be an integer")
+		}
+	case settingIntegerOrNumericString:
+		if str, ok := value.(string); ok {
+			if _, err := strconv.Atoi(str); err != nil {
+				addProblem("\"%s\" is not a number", str)
+			}
+		} else if !isInteger(value) {
+			addProblem("must be an integer")
+		}
+	case settingIntegerArray:
+		array, ok := value.([]interface{})
+		if !ok {
+			addProblem("must be an array of integers")
+			return
+		}
+		for i, elem := range array {
+			if !isInteger(elem) {
+				*problems = append(*problems, SettingProblem{Path: fmt.Sprintf("%s[%d]", path, i), Message: "must be an integer"})
+			}
+		}
+	case settingTestSettingArray:
+		array, ok := value.([]interface{})
+		if !ok {
+			addProblem("must be an array of objects")
+			return
+		}
+		if len(array) == 0 {
+			addProblem("must have at least one test setting")
+		}
+		for i, elem := range array {
+			elemPath := fmt.Sprintf("%s[%d]", path, i)
+			object, ok := elem.(map[string]interface{})
+			if !ok {
+				*problems = append(*problems, SettingProblem{Path: elemPath, Message: "must be an object"})
+				continue
+			}
+			validateObject(object, elemPath, false, problems, warnings)
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestKey returns the known key most similar to the unknown key, or empty string if nothing is similar
+func suggestKey(key string, topLevel bool) string {
+	candidates := make([]string, 0, len(testSettingSchema)+len(crossBatchRunSchema))
+	for k := range testSettingSchema {
+		candidates = append(candidates, k)
+	}
+	if topLevel {
+		for k := range crossBatchRunSchema {
+			candidates = append(candidates, k)
+		}
+	}
+	sort.Strings(candidates)
+	best := ""
+	bestDistance := 3 // allow up to 2 edits
+	for _, candidate := range candidates {
+		if distance := editDistance(key, candidate); distance < bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+	return best
+}
+
+// editDistance computes Levenshtein distance of two strings, counting a swap of adjacent characters as one edit
+func editDistance(a string, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := 0; j <= len(b); j++ {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func minInt(values ...int) int {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
@@ -0,0 +1,74 @@
+package common
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandSettingVariables(t *testing.T) {
+	os.Setenv("MAGIC_POD_TEST_ENV", "from env")
+	defer os.Unsetenv("MAGIC_POD_TEST_ENV")
+	vars := map[string]string{"FILE_NO": "12", "URL": `https://example.com/a "b" \c`}
+	tests := []struct {
+		name    string
+		setting string
+		want    string
+	}{
+		{name: "number", setting: `{"app_file_number":${FILE_NO}}`, want: `{"app_file_number":12}`},
+		{name: "string is escaped", setting: `{"app_url":"${URL}"}`, want: `{"app_url":"https://example.com/a \"b\" \\c"}`},
+		{name: "environment variable", setting: `{"model":"${MAGIC_POD_TEST_ENV}"}`, want: `{"model":"from env"}`},
+		{name: "$ without braces", setting: `{"model":"$FILE_NO"}`, want: `{"model":"$FILE_NO"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ExpandSettingVariables(test.setting, vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandSettingVariablesUndefined(t *testing.T) {
+	_, err := ExpandSettingVariables(`{"app_file_number":${MAGIC_POD_TEST_UNDEFINED}}`, nil)
+	var settingErr *SettingError
+	if !errors.As(err, &settingErr) {
+		t.Errorf("err = %v, want *SettingError", err)
+	}
+}
+
+func TestLoadSettingFileExpandsVariables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-setting-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vars := map[string]string{"URL": `https://example.com/a "b" \c`}
+	tests := []struct {
+		fileName string
+		content  string
+	}{
+		{fileName: "setting.json", content: `{"app_url": "${URL}"}`},
+		{fileName: "setting.yaml", content: `app_url: ${URL}`},
+		{fileName: "quoted.yaml", content: `app_url: '${URL}'`},
+	}
+	for _, test := range tests {
+		t.Run(test.fileName, func(t *testing.T) {
+			settingPath := filepath.Join(dir, test.fileName)
+			if err := ioutil.WriteFile(settingPath, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadSettingFile(settingPath, vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameJSON(t, got, `{"app_url":"https://example.com/a \"b\" \\c"}`)
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty"
+)
+
+// SupportedAppExtensions are extensions of files which can be uploaded. .app must be a directory of an iOS simulator app
+var SupportedAppExtensions = []string{".apk", ".aab", ".ipa", ".app", ".zip"}
+
+// ValidateAppPath checks the file exists and can be uploaded by UploadApp
+func ValidateAppPath(appPath string) error {
+	stat, err := os.Stat(appPath)
+	if err != nil {
+		return &LocalIOError{Path: appPath, Err: err}
+	}
+	ext := strings.ToLower(filepath.Ext(filepath.Clean(appPath)))
+	supported := false
+	for _, supportedExt := range SupportedAppExtensions {
+		if ext == supportedExt {
+			supported = true
+		}
+	}
+	switch {
+	case !supported:
+		return &ArgumentError{Message: fmt.Sprintf("%s cannot be uploaded. The extension must be one of %s",
+			appPath, strings.Join(SupportedAppExtensions, ", "))}
+	case ext == ".app" && !stat.IsDir():
+		return &ArgumentError{Message: fmt.Sprintf("%s is not a directory. .app must be an app bundle built for iOS simulators", appPath)}
+	case ext != ".app" && stat.IsDir():
+		return &ArgumentError{Message: fmt.Sprintf("%s is not file but directory. Only .app bundle can be uploaded as a directory", appPath)}
+	}
+	return nil
+}
+
+// UploadProgress stands for how much of a file has been sent to the server
+type UploadProgress struct {
+	FileName string
+	Sent     int64
+	Total    int64
+	Elapsed  time.Duration
+}
+
+// Percentage returns the sent ratio in 0 - 100
+func (p UploadProgress) Percentage() float64 {
+	if p.Total == 0 {
+		return 100
+	}
+	return float64(p.Sent) * 100 / float64(p.Total)
+}
+
+// BytesPerSecond returns the average throughput so far
+func (p UploadProgress) BytesPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Sent) / p.Elapsed.Seconds()
+}
+
+// ETA returns the estimated time until the upload finishes. ok is false if it cannot be estimated yet
+func (p UploadProgress) ETA() (eta time.Duration, ok bool) {
+	bytesPerSecond := p.BytesPerSecond()
+	if bytesPerSecond == 0 {
+		return 0, false
+	}
+	return time.Duration(float64(p.Total-p.Sent) / bytesPerSecond * float64(time.Second)), true
+}
+
+// UploadProgressFunc is called periodically while a file is uploaded, and once when all bytes have been sent
+type UploadProgressFunc func(progress UploadProgress)
+
+// WithUploadProgress sets the function to be notified of the progress of UploadApp
+func WithUploadProgress(onProgress UploadProgressFunc) ClientOption {
+	return func(c *Client) {
+		c.onUploadProgress = onProgress
+	}
+}
+
+const (
+	// streamLengthHeader passes the length of a streamed body to setStreamContentLength.
+	// It is removed before the request is sent
+	streamLengthHeader = "X-Magic-Pod-Client-Stream-Length"
+	// progressInterval is the minimum interval to call UploadProgressFunc
+	progressInterval = 200 * time.Millisecond
+)
+
+// setStreamContentLength sets Content-Length of a request with a streamed body, which resty does not set
+func setStreamContentLength(_ *resty.Client, req *resty.Request) error {
+	length := req.RawRequest.Header.Get(streamLengthHeader)
+	if length == "" {
+		return nil
+	}
+	// the header is shared with the resty.Request, so remove it from a copy to keep it for retries
+	req.RawRequest.Header = req.RawRequest.Header.Clone()
+	req.RawRequest.Header.Del(streamLengthHeader)
+	contentLength, err := strconv.ParseInt(length, 10, 64)
+	if err != nil {
+		return err
+	}
+	req.RawRequest.ContentLength = contentLength
+	return nil
+}
+
+// rewinder is implemented by request bodies which can be sent again on retry
+type rewinder interface {
+	rewind() error
+}
+
+// multipartFileBody streams a multipart/form-data body with a single file from disk without buffering the file
+type multipartFileBody struct {
+	file        *os.File
+	fileName    string
+	fileSize    int64
+	header      []byte
+	footer      []byte
+	contentType string
+	onProgress  UploadProgressFunc
+	reader      io.Reader
+	mutex       sync.Mutex
+	sent        int64
+	startTime   time.Time
+	lastNotify  time.Time
+}
+
+func newMultipartFileBody(fieldName string, filePath string, onProgress UploadProgressFunc) (*multipartFileBody, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, &LocalIOError{Path: filePath, Err: err}
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, &LocalIOError{Path: filePath, Err: err}
+	}
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if _, err := writer.CreateFormFile(fieldName, filepath.Base(filePath)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	header := append([]byte{}, buf.Bytes()...)
+	buf.Reset()
+	if err := writer.Close(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	body := &multipartFileBody{
+		file:        file,
+		fileName:    filepath.Base(filePath),
+		fileSize:    stat.Size(),
+		header:      header,
+		footer:      append([]byte{}, buf.Bytes()...),
+		contentType: writer.FormDataContentType(),
+		onProgress:  onProgress,
+	}
+	body.reader = body.newReader()
+	return body, nil
+}
+
+func (b *multipartFileBody) newReader() io.Reader {
+	b.sent = 0
+	b.startTime = time.Now()
+	b.lastNotify = time.Time{}
+	return io.MultiReader(bytes.NewReader(b.header), &progressReader{body: b}, bytes.NewReader(b.footer))
+}
+
+func (b *multipartFileBody) contentLength() int64 {
+	return int64(len(b.header)) + b.fileSize + int64(len(b.footer))
+}
+
+func (b *multipartFileBody) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
+
+func (b *multipartFileBody) rewind() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return &LocalIOError{Path: b.file.Name(), Err: err}
+	}
+	b.reader = b.newReader()
+	return nil
+}
+
+// close closes the file. It is not Close since net/http closes an io.Closer body after sending it, which prevents rewind
+func (b *multipartFileBody) close() error {
+	return b.file.Close()
+}
+
+func (b *multipartFileBody) notify(n int) {
+	if b.onProgress == nil {
+		return
+	}
+	b.mutex.Lock()
+	b.sent += int64(n)
+	now := time.Now()
+	finished := b.sent >= b.fileSize
+	if !finished && now.Sub(b.lastNotify) < progressInterval {
+		b.mutex.Unlock()
+		return
+	}
+	b.lastNotify = now
+	progress := UploadProgress{FileName: b.fileName, Sent: b.sent, Total: b.fileSize, Elapsed: now.Sub(b.startTime)}
+	b.mutex.Unlock()
+	b.onProgress(progress)
+}
+
+// progressReader reads the file of multipartFileBody and notifies the progress
+type progressReader struct {
+	body *multipartFileBody
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.body.file.Read(p)
+	if n > 0 {
+		r.body.notify(n)
+	}
+	return n, err
+}
@@ -0,0 +1,261 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// uploadCacheEntry stands for a file uploaded before
+type uploadCacheEntry struct {
+	File_No     int
+	File_Name   string
+	Uploaded_At string
+}
+
+// uploadCache maps "<url base> <organization>/<project>" to SHA-256 of uploaded files to their entries
+type uploadCache map[string]map[string]uploadCacheEntry
+
+// uploadCacheMutex serializes updates of the cache file by concurrent uploads in this process
+var uploadCacheMutex sync.Mutex
+
+// WithUploadCache enables the file which records hashes of uploaded files for UploadAppDeduplicated.
+// Files deleted by DeleteApp are removed from it. Failures to update it are not errors, and are passed to onWarning if not nil
+func WithUploadCache(path string, onWarning func(err error)) ClientOption {
+	return func(c *Client) {
+		c.uploadCachePath = path
+		c.onCacheWarning = onWarning
+	}
+}
+
+// DefaultUploadCachePath returns the path of the upload cache under the user cache directory, or empty string if it is unknown
+func DefaultUploadCachePath() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, "magic-pod", "uploaded-files.json")
+}
+
+func (c *Client) uploadCacheKey() string {
+	return fmt.Sprintf("%s %s/%s", c.urlBase, c.organization, c.project)
+}
+
+func readUploadCache(path string) (uploadCache, error) {
+	cache := make(uploadCache)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, &LocalIOError{Path: path, Err: err}
+	}
+	if err := json.Unmarshal(content, &cache); err != nil {
+		// a broken cache only makes the next upload not deduplicated
+		return make(uploadCache), nil
+	}
+	return cache, nil
+}
+
+// writeUploadCache replaces the cache file atomically so that concurrent jobs do not see a partial file
+func writeUploadCache(path string, cache uploadCache) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return &LocalIOError{Path: filepath.Dir(path), Err: err}
+	}
+	content, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), ".uploaded-files-")
+	if err != nil {
+		return &LocalIOError{Path: filepath.Dir(path), Err: err}
+	}
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return &LocalIOError{Path: path, Err: err}
+	}
+	return nil
+}
+
+// hashApp computes SHA-256 of an app file. For an .app bundle, paths, permissions, link targets and contents
+// of its entries are hashed instead of a zip file, since zip files differ by timestamps
+func hashApp(appPath string) (string, error) {
+	stat, err := os.Stat(appPath)
+	if err != nil {
+		return "", &LocalIOError{Path: appPath, Err: err}
+	}
+	h := sha256.New()
+	if !stat.IsDir() {
+		if err := hashFileContent(h, appPath); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	// filepath.Walk does not follow appPath itself if it is a symbolic link
+	rootDir, err := filepath.EvalSymlinks(appPath)
+	if err != nil {
+		return "", &LocalIOError{Path: appPath, Err: err}
+	}
+	// filepath.Walk visits entries in lexical order, so the hash is stable
+	err = filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return &LocalIOError{Path: path, Err: err}
+		}
+		relPath, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return &LocalIOError{Path: path, Err: err}
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00", filepath.ToSlash(relPath), info.Mode())
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return &LocalIOError{Path: path, Err: err}
+			}
+			fmt.Fprintf(h, "%s\x00", filepath.ToSlash(target))
+		} else if info.Mode().IsRegular() {
+			return hashFileContent(h, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFileContent(h hash.Hash, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return &LocalIOError{Path: path, Err: err}
+	}
+	defer file.Close()
+	if _, err := io.Copy(h, file); err != nil {
+		return &LocalIOError{Path: path, Err: err}
+	}
+	return nil
+}
+
+// UploadAppDeduplicated uploads app/ipa/apk file unless the identical file has been uploaded to the project
+// by this machine before and still exists on the server. reused is true if the number of the file uploaded before is returned.
+// The cache must be enabled by WithUploadCache. It is only a hint, so failures to update it are passed to its onWarning
+func (c *Client) UploadAppDeduplicated(appPath string) (fileNo int, reused bool, err error) {
+	return c.UploadAppDeduplicatedContext(context.Background(), appPath)
+}
+
+// UploadAppDeduplicatedContext is the same as UploadAppDeduplicated except that the request is canceled when ctx is done
+func (c *Client) UploadAppDeduplicatedContext(ctx context.Context, appPath string) (fileNo int, reused bool, err error) {
+	if c.uploadCachePath == "" {
+		return 0, false, &ArgumentError{Message: "the upload cache is not enabled by WithUploadCache"}
+	}
+	hash, err := hashApp(appPath)
+	if err != nil {
+		return 0, false, err
+	}
+	cache, cacheErr := readUploadCache(c.uploadCachePath)
+	if cacheErr != nil {
+		c.warnUploadCache(cacheErr)
+	}
+	if entry, ok := cache[c.uploadCacheKey()][hash]; ok {
+		// the file may have been deleted by the web UI, another machine or expiration
+		exists, err := c.appFileExists(ctx, entry.File_No)
+		if err == nil && exists {
+			return entry.File_No, true, nil
+		}
+		// upload it again also when the list is not available, since a number which may not exist must not be returned
+		if err == nil {
+			if err := c.forgetUploadedFile(entry.File_No); err != nil {
+				c.warnUploadCache(err)
+			}
+		}
+	}
+
+	fileNo, err = c.UploadAppContext(ctx, appPath)
+	if err != nil || cacheErr != nil {
+		// the cache is not updated if it could not be read
+		return fileNo, false, err
+	}
+	// read again since other uploads may have updated the cache during the upload
+	uploadCacheMutex.Lock()
+	defer uploadCacheMutex.Unlock()
+	cache, err = readUploadCache(c.uploadCachePath)
+	if err != nil {
+		c.warnUploadCache(err)
+		return fileNo, false, nil
+	}
+	if cache[c.uploadCacheKey()] == nil {
+		cache[c.uploadCacheKey()] = make(map[string]uploadCacheEntry)
+	}
+	cache[c.uploadCacheKey()][hash] = uploadCacheEntry{
+		File_No:     fileNo,
+		File_Name:   filepath.Base(appPath),
+		Uploaded_At: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := writeUploadCache(c.uploadCachePath, cache); err != nil {
+		c.warnUploadCache(err)
+	}
+	return fileNo, false, nil
+}
+
+// appFileExists reports whether the file is listed by ListApps
+func (c *Client) appFileExists(ctx context.Context, fileNo int) (bool, error) {
+	appFiles, err := c.ListAppsContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, appFile := range appFiles {
+		if appFile.File_No == fileNo {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *Client) warnUploadCache(err error) {
+	if c.onCacheWarning != nil {
+		c.onCacheWarning(err)
+	}
+}
+
+// forgetUploadedFile removes the deleted file from the cache so that it is not reused
+func (c *Client) forgetUploadedFile(fileNo int) error {
+	if c.uploadCachePath == "" {
+		return nil
+	}
+	if _, err := os.Stat(c.uploadCachePath); os.IsNotExist(err) {
+		return nil
+	}
+	uploadCacheMutex.Lock()
+	defer uploadCacheMutex.Unlock()
+	cache, err := readUploadCache(c.uploadCachePath)
+	if err != nil {
+		return err
+	}
+	entries := cache[c.uploadCacheKey()]
+	found := false
+	for hash, entry := range entries {
+		if entry.File_No == fileNo {
+			delete(entries, hash)
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return writeUploadCache(c.uploadCachePath, cache)
+}
@@ -0,0 +1,277 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeAppServer stands for the files stored in a project
+type fakeAppServer struct {
+	mutex      sync.Mutex
+	files      map[int]bool
+	nextFileNo int
+	uploads    int
+	listFails  bool
+}
+
+func newFakeAppServer() (*fakeAppServer, *httptest.Server) {
+	s := &fakeAppServer{files: make(map[int]bool), nextFileNo: 1}
+	return s, httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodPost:
+			ioutil.ReadAll(r.Body)
+			s.uploads++
+			s.files[s.nextFileNo] = true
+			fmt.Fprintf(w, `{"file_no":%d}`, s.nextFileNo)
+			s.nextFileNo++
+		case http.MethodGet:
+			if s.listFails {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			appFiles := AppFiles{App_Files: []AppFile{}}
+			for fileNo := range s.files {
+				appFiles.App_Files = append(appFiles.App_Files, AppFile{File_No: fileNo})
+			}
+			json.NewEncoder(w).Encode(&appFiles)
+		case http.MethodDelete:
+			var body struct{ App_File_Number int }
+			json.NewDecoder(r.Body).Decode(&body)
+			delete(s.files, body.App_File_Number)
+			w.Write([]byte(`{}`))
+		}
+	}))
+}
+
+func TestHashApp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-hash-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := createAppBundle(t, dir)
+	if err := os.Symlink(bundle, filepath.Join(dir, "Linked.app")); err != nil {
+		t.Fatal(err)
+	}
+	for i, content := range []string{"app", "app", "other app"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.apk", i)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := func(name string) string {
+		h, err := hashApp(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	bundleHash := hash("MyApp.app")
+	tests := []struct {
+		name  string
+		path1 string
+		path2 string
+		same  bool
+	}{
+		{name: "same content", path1: "0.apk", path2: "1.apk", same: true},
+		{name: "different content", path1: "0.apk", path2: "2.apk"},
+		{name: "symbolic link to the bundle", path1: "MyApp.app", path2: "Linked.app", same: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if same := hash(test.path1) == hash(test.path2); same != test.same {
+				t.Errorf("same = %v, want %v", same, test.same)
+			}
+		})
+	}
+	// permissions of the bundle entries are hashed
+	if err := os.Chmod(filepath.Join(bundle, "MyApp"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if hash("MyApp.app") == bundleHash {
+		t.Error("the hash is not changed by the permission")
+	}
+}
+
+func TestUploadAppDeduplicated(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare changes the server after the first upload
+		prepare     func(s *fakeAppServer)
+		wantFileNo  int
+		wantReused  bool
+		wantUploads int
+		// wantCached is the file number recorded in the cache at last
+		wantCached int
+	}{
+		{
+			name:        "file uploaded before is reused",
+			prepare:     func(s *fakeAppServer) {},
+			wantFileNo:  1,
+			wantReused:  true,
+			wantUploads: 1,
+			wantCached:  1,
+		},
+		{
+			name:        "file deleted on the server is uploaded again",
+			prepare:     func(s *fakeAppServer) { delete(s.files, 1) },
+			wantFileNo:  2,
+			wantUploads: 2,
+			wantCached:  2,
+		},
+		{
+			name:        "file is uploaded again if the list is not available",
+			prepare:     func(s *fakeAppServer) { s.listFails = true },
+			wantFileNo:  2,
+			wantUploads: 2,
+			wantCached:  2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "magic-pod-dedupe-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			appPath := filepath.Join(dir, "app.apk")
+			if err := ioutil.WriteFile(appPath, []byte("app"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cachePath := filepath.Join(dir, "cache", "uploaded-files.json")
+			fake, server := newFakeAppServer()
+			defer server.Close()
+			var warnings []error
+			client := newTestClient(server, WithUploadCache(cachePath, func(err error) { warnings = append(warnings, err) }))
+
+			if fileNo, reused, err := client.UploadAppDeduplicated(appPath); err != nil || fileNo != 1 || reused {
+				t.Fatalf("first upload = %d, %v, %v, want 1, false, nil", fileNo, reused, err)
+			}
+			test.prepare(fake)
+			fileNo, reused, err := client.UploadAppDeduplicated(appPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fileNo != test.wantFileNo || reused != test.wantReused {
+				t.Errorf("got %d, %v, want %d, %v", fileNo, reused, test.wantFileNo, test.wantReused)
+			}
+			if fake.uploads != test.wantUploads {
+				t.Errorf("uploaded %d times, want %d", fake.uploads, test.wantUploads)
+			}
+			cache, err := readUploadCache(cachePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cached []int
+			for _, entry := range cache[client.uploadCacheKey()] {
+				cached = append(cached, entry.File_No)
+			}
+			if len(cached) != 1 || cached[0] != test.wantCached {
+				t.Errorf("cached %v, want [%d]", cached, test.wantCached)
+			}
+			if len(warnings) > 0 {
+				t.Errorf("warnings %v", warnings)
+			}
+		})
+	}
+}
+
+func TestUploadCacheIsOptIn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-dedupe-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	appPath := filepath.Join(dir, "app.apk")
+	if err := ioutil.WriteFile(appPath, []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, server := newFakeAppServer()
+	defer server.Close()
+	client := newTestClient(server)
+
+	_, _, err = client.UploadAppDeduplicated(appPath)
+	var argumentErr *ArgumentError
+	if !errors.As(err, &argumentErr) {
+		t.Errorf("err = %v, want *ArgumentError", err)
+	}
+	if err := client.DeleteApp(1); err != nil {
+		t.Fatal(err)
+	}
+	if client.uploadCachePath != "" {
+		t.Errorf("cache path is %s without WithUploadCache", client.uploadCachePath)
+	}
+}
+
+func TestUploadCacheFailuresAreWarnings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-dedupe-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	appPath := filepath.Join(dir, "app.apk")
+	if err := ioutil.WriteFile(appPath, []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the cache cannot be created under a regular file
+	cachePath := filepath.Join(appPath, "uploaded-files.json")
+	fake, server := newFakeAppServer()
+	defer server.Close()
+	var warnings []error
+	client := newTestClient(server, WithUploadCache(cachePath, func(err error) { warnings = append(warnings, err) }))
+
+	fileNo, reused, err := client.UploadAppDeduplicated(appPath)
+	if err != nil || fileNo != 1 || reused {
+		t.Errorf("got %d, %v, %v, want 1, false, nil", fileNo, reused, err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("warnings %v, want one", warnings)
+	}
+	if err := client.DeleteApp(fileNo); err != nil {
+		t.Errorf("DeleteApp failed by the cache: %s", err)
+	}
+	if fake.files[fileNo] {
+		t.Error("the file is not deleted")
+	}
+}
+
+func TestDeleteAppForgetsUploadedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-dedupe-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	appPath := filepath.Join(dir, "app.apk")
+	if err := ioutil.WriteFile(appPath, []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cachePath := filepath.Join(dir, "uploaded-files.json")
+	_, server := newFakeAppServer()
+	defer server.Close()
+	client := newTestClient(server, WithUploadCache(cachePath, nil))
+
+	fileNo, _, err := client.UploadAppDeduplicated(appPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeleteApp(fileNo); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := readUploadCache(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := cache[client.uploadCacheKey()]; len(entries) != 0 {
+		t.Errorf("cache has %v after the deletion", entries)
+	}
+}
@@ -0,0 +1,165 @@
+package common
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateAppPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-upload-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"app.apk", "app.IPA", "app.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("app"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"MyApp.app", "dir.apk"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.app"), []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		wantErr   bool
+		wantIOErr bool
+	}{
+		{name: "app.apk"},
+		{name: "app.IPA"},
+		{name: "MyApp.app"},
+		{name: "MyApp.app/"},
+		{name: "app.txt", wantErr: true},
+		{name: "file.app", wantErr: true},
+		{name: "dir.apk", wantErr: true},
+		{name: "missing.apk", wantIOErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateAppPath(filepath.Join(dir, test.name))
+			var argumentErr *ArgumentError
+			var localIOErr *LocalIOError
+			switch {
+			case test.wantErr && !errors.As(err, &argumentErr):
+				t.Errorf("err = %v, want *ArgumentError", err)
+			case test.wantIOErr && !errors.As(err, &localIOErr):
+				t.Errorf("err = %v, want *LocalIOError", err)
+			case !test.wantErr && !test.wantIOErr && err != nil:
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestUploadProgress(t *testing.T) {
+	tests := []struct {
+		name           string
+		progress       UploadProgress
+		wantPercentage float64
+		wantETA        time.Duration
+		wantETAOk      bool
+	}{
+		{name: "half", progress: UploadProgress{Sent: 50, Total: 100, Elapsed: 2 * time.Second}, wantPercentage: 50, wantETA: 2 * time.Second, wantETAOk: true},
+		{name: "finished", progress: UploadProgress{Sent: 100, Total: 100, Elapsed: time.Second}, wantPercentage: 100, wantETAOk: true},
+		{name: "not started", progress: UploadProgress{Total: 100}, wantPercentage: 0},
+		{name: "empty file", progress: UploadProgress{}, wantPercentage: 100},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.progress.Percentage(); got != test.wantPercentage {
+				t.Errorf("Percentage() = %v, want %v", got, test.wantPercentage)
+			}
+			eta, ok := test.progress.ETA()
+			if eta != test.wantETA || ok != test.wantETAOk {
+				t.Errorf("ETA() = %s, %v, want %s, %v", eta, ok, test.wantETA, test.wantETAOk)
+			}
+		})
+	}
+}
+
+// newUploadServer returns a server which fails the first failures uploads with 503, and records the uploaded files
+func newUploadServer(t *testing.T, failures int, uploaded map[string]string) *httptest.Server {
+	attempts := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if r.ContentLength != int64(len(body)) {
+			t.Errorf("Content-Length = %d, but %d bytes are sent", r.ContentLength, len(body))
+		}
+		if r.Header.Get(streamLengthHeader) != "" {
+			t.Errorf("%s is sent", streamLengthHeader)
+		}
+		if attempts <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(file)
+		uploaded[header.Filename] = string(content)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"file_no":12}`))
+	}))
+}
+
+func TestUploadApp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-upload-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := strings.Repeat("0123456789", 100000)
+	appPath := filepath.Join(dir, "app.apk")
+	if err := ioutil.WriteFile(appPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		failures int
+	}{
+		{name: "first attempt"},
+		{name: "body is sent again on retry", failures: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uploaded := make(map[string]string)
+			server := newUploadServer(t, test.failures, uploaded)
+			defer server.Close()
+			var last UploadProgress
+			client := newTestClient(server,
+				WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, RetryNonIdempotent: true}),
+				WithUploadProgress(func(progress UploadProgress) { last = progress }))
+
+			fileNo, err := client.UploadApp(appPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fileNo != 12 {
+				t.Errorf("fileNo = %d, want 12", fileNo)
+			}
+			if uploaded["app.apk"] != content {
+				t.Errorf("uploaded %d bytes, want %d bytes", len(uploaded["app.apk"]), len(content))
+			}
+			if last.FileName != "app.apk" || last.Sent != int64(len(content)) || last.Total != int64(len(content)) {
+				t.Errorf("last progress = %+v, want all bytes sent", last)
+			}
+		})
+	}
+}
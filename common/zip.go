@@ -0,0 +1,114 @@
+package common
+
+import (
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// zipAppDir zips an .app bundle into a temporary directory without touching the source tree.
+// Symbolic links are stored as links and permissions like executable bits are kept,
+// which iOS simulator apps depend on. cleanup removes the zip file
+func zipAppDir(dirPath string) (zipPath string, cleanup func(), err error) {
+	tmpDir, err := ioutil.TempDir("", "magic-pod-app-")
+	if err != nil {
+		return "", nil, &LocalIOError{Path: os.TempDir(), Err: err}
+	}
+	cleanup = func() {
+		os.RemoveAll(tmpDir)
+	}
+	zipPath = filepath.Join(tmpDir, filepath.Base(dirPath)+".zip")
+	if err := writeZip(dirPath, zipPath); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return zipPath, cleanup, nil
+}
+
+func writeZip(dirPath string, zipPath string) error {
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		return &LocalIOError{Path: zipPath, Err: err}
+	}
+	writer := zip.NewWriter(zipFile)
+	// entries start with the bundle name like MyApp.app/Info.plist even if dirPath is a symbolic link
+	// to a directory with another name. filepath.Walk does not follow dirPath itself if it is a link
+	bundleName := filepath.Base(filepath.Clean(dirPath))
+	rootDir, err := filepath.EvalSymlinks(dirPath)
+	if err != nil {
+		err = &LocalIOError{Path: dirPath, Err: err}
+	} else {
+		err = filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return &LocalIOError{Path: path, Err: err}
+			}
+			return addZipEntry(writer, rootDir, bundleName, path, info)
+		})
+	}
+	if closeErr := writer.Close(); err == nil && closeErr != nil {
+		err = &LocalIOError{Path: zipPath, Err: closeErr}
+	}
+	if closeErr := zipFile.Close(); err == nil && closeErr != nil {
+		err = &LocalIOError{Path: zipPath, Err: closeErr}
+	}
+	return err
+}
+
+// addZipEntry adds a file, a directory or a symbolic link in rootDir as an entry under bundleName.
+// filepath.Walk does not follow symbolic links
+func addZipEntry(writer *zip.Writer, rootDir string, bundleName string, path string, info os.FileInfo) error {
+	relPath, err := filepath.Rel(rootDir, path)
+	if err != nil {
+		return &LocalIOError{Path: path, Err: err}
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return &LocalIOError{Path: path, Err: err}
+	}
+	header.Name = filepath.ToSlash(filepath.Join(bundleName, relPath))
+	switch {
+	case info.IsDir():
+		header.Name += "/"
+		header.Method = zip.Store
+		_, err = writer.CreateHeader(header)
+	case info.Mode()&os.ModeSymlink != 0:
+		// the content of a symbolic link entry is its target
+		var target string
+		target, err = os.Readlink(path)
+		if err != nil {
+			return &LocalIOError{Path: path, Err: err}
+		}
+		header.Method = zip.Store
+		var w io.Writer
+		if w, err = writer.CreateHeader(header); err == nil {
+			_, err = io.WriteString(w, filepath.ToSlash(target))
+		}
+	default:
+		header.Method = zip.Deflate
+		var w io.Writer
+		if w, err = writer.CreateHeader(header); err == nil {
+			err = copyFile(w, path)
+		}
+	}
+	if err != nil {
+		if _, ok := err.(*LocalIOError); ok {
+			return err
+		}
+		return &LocalIOError{Path: path, Err: err}
+	}
+	return nil
+}
+
+func copyFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return &LocalIOError{Path: path, Err: err}
+	}
+	defer file.Close()
+	if _, err := io.Copy(w, file); err != nil {
+		return &LocalIOError{Path: path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package common
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// createAppBundle creates MyApp.app with an executable, a file in a subdirectory and a symbolic link in dir
+func createAppBundle(t *testing.T, dir string) string {
+	t.Helper()
+	bundle := filepath.Join(dir, "MyApp.app")
+	if err := os.MkdirAll(filepath.Join(bundle, "Frameworks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := []struct {
+		name    string
+		content string
+		mode    os.FileMode
+	}{
+		{name: "Info.plist", content: "plist", mode: 0644},
+		{name: "MyApp", content: "binary", mode: 0755},
+		{name: "Frameworks/Lib", content: "library", mode: 0644},
+	}
+	for _, file := range files {
+		filePath := filepath.Join(bundle, filepath.FromSlash(file.name))
+		if err := ioutil.WriteFile(filePath, []byte(file.content), file.mode); err != nil {
+			t.Fatal(err)
+		}
+		// not to depend on umask
+		if err := os.Chmod(filePath, file.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("Frameworks/Lib", filepath.Join(bundle, "Current")); err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
+
+// zipEntries returns the mode and the content of each entry, or the target of each symbolic link
+func zipEntries(t *testing.T, zipPath string) map[string]string {
+	t.Helper()
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	entries := make(map[string]string)
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			entries[file.Name] = "dir"
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.Mode()&os.ModeSymlink != 0 {
+			// permissions of links differ among platforms
+			entries[file.Name] = "link " + string(content)
+		} else {
+			entries[file.Name] = file.Mode().String() + " " + string(content)
+		}
+	}
+	return entries
+}
+
+func TestZipAppDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magic-pod-zip-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := createAppBundle(t, dir)
+	if err := os.Symlink(bundle, filepath.Join(dir, "Linked.app")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		path       string
+		bundleName string
+	}{
+		{name: "bundle", path: bundle, bundleName: "MyApp.app"},
+		{name: "trailing separator", path: bundle + string(filepath.Separator), bundleName: "MyApp.app"},
+		{name: "symbolic link to the bundle", path: filepath.Join(dir, "Linked.app"), bundleName: "Linked.app"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			zipPath, cleanup, err := zipAppDir(test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{
+				test.bundleName + "/":               "dir",
+				test.bundleName + "/Info.plist":     "-rw-r--r-- plist",
+				test.bundleName + "/MyApp":          "-rwxr-xr-x binary",
+				test.bundleName + "/Frameworks/":    "dir",
+				test.bundleName + "/Frameworks/Lib": "-rw-r--r-- library",
+				test.bundleName + "/Current":        "link Frameworks/Lib",
+			}
+			if got := zipEntries(t, zipPath); !reflect.DeepEqual(got, want) {
+				t.Errorf("entries = %v, want %v", got, want)
+			}
+			if rel, err := filepath.Rel(dir, zipPath); err == nil && rel[0] != '.' {
+				t.Errorf("%s is written next to the bundle", zipPath)
+			}
+			cleanup()
+			if _, err := os.Stat(zipPath); !os.IsNotExist(err) {
+				t.Errorf("%s is not removed by cleanup", zipPath)
+			}
+		})
+	}
+	// the source tree is not changed
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("%d files in %s, want only the bundle and the link", len(infos), dir)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Magic-Pod/magic-pod-api-client/common"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultProfileName = "default"
+	localConfigName    = ".magicpod.yaml"
+)
+
+// profile holds values used instead of command line options which are not specified
+type profile struct {
+	Token        string                     `yaml:"token"`
+	Organization string                     `yaml:"organization"`
+	Project      string                     `yaml:"project"`
+	URLBase      string                     `yaml:"url_base"`
+	HTTPHeaders  map[string]string          `yaml:"http_headers"`
+	Settings     map[string]*profileSetting `yaml:"settings"` // batch run settings by name
+}
+
+// profileSetting is a batch run setting written either as a YAML object or as a JSON string
+type profileSetting struct {
+	value interface{}
+}
+
+func (s *profileSetting) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	s.value, err = common.DecodeYAMLSetting(unmarshal)
+	return err
+}
+
+type config struct {
+	DefaultProfile string              `yaml:"default_profile"`
+	Profiles       map[string]*profile `yaml:"profiles"`
+}
+
+// userConfigPath returns ~/.config/magic-pod/config.yaml, or the path under $XDG_CONFIG_HOME if it is set
+func userConfigPath() string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		configHome = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(configHome, "magic-pod", "config.yaml")
+}
+
+// readConfig returns nil if the file does not exist
+func readConfig(path string) (*config, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &common.LocalIOError{Path: path, Err: err}
+	}
+	var conf config
+	if err := yaml.UnmarshalStrict(bytes, &conf); err != nil {
+		return nil, cli.NewExitError(fmt.Sprintf("%s: %s", path, err), 1)
+	}
+	return &conf, nil
+}
+
+// mergeProfile overwrites dst by non-empty values of src
+func mergeProfile(dst *profile, src *profile) {
+	if src.Token != "" {
+		dst.Token = src.Token
+	}
+	if src.Organization != "" {
+		dst.Organization = src.Organization
+	}
+	if src.Project != "" {
+		dst.Project = src.Project
+	}
+	if src.URLBase != "" {
+		dst.URLBase = src.URLBase
+	}
+	for k, v := range src.HTTPHeaders {
+		dst.HTTPHeaders[k] = v
+	}
+	for k, v := range src.Settings {
+		dst.Settings[k] = v
+	}
+}
+
+// isSameFile reports whether both paths exist and point to the same file
+func isSameFile(path1 string, path2 string) bool {
+	stat1, err := os.Stat(path1)
+	if err != nil {
+		return false
+	}
+	stat2, err := os.Stat(path2)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(stat1, stat2)
+}
+
+// withoutCredentials returns p without token and url_base, which are ignored in .magicpod.yaml.
+// The file is usually committed to the repository, so url_base in it could send the token given by CI to another host
+func withoutCredentials(p *profile) *profile {
+	if p.Token == "" && p.URLBase == "" {
+		return p
+	}
+	fmt.Fprintf(os.Stderr, "warning: token and url_base in %s are ignored. Specify the file by --config to use them\n", localConfigName)
+	copied := *p
+	copied.Token = ""
+	copied.URLBase = ""
+	return &copied
+}
+
+// loadProfile reads the profile specified by --profile from the user config file and .magicpod.yaml
+// in the current directory. Values in .magicpod.yaml take precedence except token and url_base.
+// An empty profile is returned if no config file exists and --profile is not specified
+func loadProfile(c *cli.Context) (*profile, error) {
+	return loadProfileFrom(c.GlobalString("config"), c.GlobalString("profile"))
+}
+
+// loadProfileFrom is the same as loadProfile except that the config file and the profile name are given as arguments
+func loadProfileFrom(configPath string, profileName string) (*profile, error) {
+	if configPath == "" {
+		configPath = userConfigPath()
+	}
+	var configs []*config
+	var localConf *config
+	if configPath != "" {
+		conf, err := readConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if conf != nil {
+			configs = append(configs, conf)
+		}
+	}
+	// .magicpod.yaml specified by --config is trusted as the user config file
+	if !isSameFile(configPath, localConfigName) {
+		conf, err := readConfig(localConfigName)
+		if err != nil {
+			return nil, err
+		}
+		if conf != nil {
+			configs = append(configs, conf)
+			localConf = conf
+		}
+	}
+
+	explicit := profileName != ""
+	if !explicit {
+		profileName = defaultProfileName
+		for _, conf := range configs {
+			if conf.DefaultProfile != "" {
+				profileName = conf.DefaultProfile
+				explicit = true
+			}
+		}
+	}
+	result := &profile{HTTPHeaders: map[string]string{}, Settings: map[string]*profileSetting{}}
+	found := false
+	for _, conf := range configs {
+		if p, ok := conf.Profiles[profileName]; ok && p != nil {
+			if conf == localConf {
+				p = withoutCredentials(p)
+			}
+			mergeProfile(result, p)
+			found = true
+		}
+	}
+	if explicit && !found {
+		return nil, cli.NewExitError(fmt.Sprintf("profile '%s' is not defined in %s or %s", profileName, configPath, localConfigName), 1)
+	}
+	return result, nil
+}
+
+// namedSetting returns the batch run setting defined in the profile in JSON format.
+// The setting can be written either as a YAML object or as a JSON string
+func namedSetting(c *cli.Context, name string) (string, error) {
+	p, err := loadProfile(c)
+	if err != nil {
+		return "", err
+	}
+	setting, ok := p.Settings[name]
+	if !ok || setting == nil {
+		return "", cli.NewExitError(fmt.Sprintf("setting '%s' is not defined in the profile", name), 1)
+	}
+	if str, ok := setting.value.(string); ok {
+		return str, nil
+	}
+	settingJSON, err := common.SettingToJSON(setting.value)
+	if err != nil {
+		return "", cli.NewExitError(fmt.Sprintf("setting '%s' cannot be converted to JSON: %s", name, err), 1)
+	}
+	return settingJSON, nil
+}
+
+// settingVars parses --var options in key=value format
+func settingVars(c *cli.Context) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, v := range c.StringSlice("var") {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, cli.NewExitError(fmt.Sprintf("--var must be in key=value format: %s", v), 1)
+		}
+		vars[kv[0]] = kv[1]
+	}
+	return vars, nil
+}
+
+// settingFromFlags returns the setting specified by either of --setting, --setting_name or --setting_file.
+// Variables are expanded in the file, and in the others only if --var is specified
+// since they may have ${...} as a part of values
+func settingFromFlags(c *cli.Context) (string, error) {
+	setting := c.String("setting")
+	settingName := c.String("setting_name")
+	settingFile := c.String("setting_file")
+	specified := 0
+	for _, value := range []string{setting, settingName, settingFile} {
+		if value != "" {
+			specified++
+		}
+	}
+	if specified > 1 {
+		return "", cli.NewExitError("only one of --setting, --setting_name and --setting_file can be specified", 1)
+	}
+	vars, err := settingVars(c)
+	if err != nil {
+		return "", err
+	}
+	if settingFile != "" {
+		return common.LoadSettingFile(settingFile, vars)
+	}
+	if settingName != "" {
+		setting, err = namedSetting(c, settingName)
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(vars) == 0 {
+		return setting, nil
+	}
+	return common.ExpandSettingVariables(setting, vars)
+}
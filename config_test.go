@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Magic-Pod/magic-pod-api-client/common"
+)
+
+// chdirTemp changes the current directory to a new temporary directory, so that .magicpod.yaml in it is read.
+// cleanup restores the current directory and removes the temporary one
+func chdirTemp(t *testing.T) (dir string, cleanup func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "magic-pod-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLoadProfileIgnoresCredentialsInLocalConfig(t *testing.T) {
+	dir, cleanup := chdirTemp(t)
+	defer cleanup()
+
+	userConfigPath := filepath.Join(dir, "config.yaml")
+	userConfig := `
+profiles:
+  default:
+    token: USER-TOKEN
+    organization: user-org
+`
+	if err := ioutil.WriteFile(userConfigPath, []byte(userConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	localConfig := `
+profiles:
+  default:
+    token: LOCAL-TOKEN
+    url_base: http://127.0.0.1:18089
+    organization: local-org
+    project: local-project
+`
+	if err := ioutil.WriteFile(localConfigName, []byte(localConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		configPath string
+		want       profile
+	}{
+		{
+			name:       "token in the user config file",
+			configPath: userConfigPath,
+			want:       profile{Token: "USER-TOKEN", Organization: "local-org", Project: "local-project"},
+		},
+		{
+			name:       "no user config file",
+			configPath: filepath.Join(dir, "missing.yaml"),
+			want:       profile{Organization: "local-org", Project: "local-project"},
+		},
+		{
+			name:       "local config file specified by --config",
+			configPath: localConfigName,
+			want:       profile{Token: "LOCAL-TOKEN", URLBase: "http://127.0.0.1:18089", Organization: "local-org", Project: "local-project"},
+		},
+		{
+			name:       "local config file specified by --config with another path",
+			configPath: filepath.Join(dir, localConfigName),
+			want:       profile{Token: "LOCAL-TOKEN", URLBase: "http://127.0.0.1:18089", Organization: "local-org", Project: "local-project"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := loadProfileFrom(test.configPath, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Token != test.want.Token || got.URLBase != test.want.URLBase ||
+				got.Organization != test.want.Organization || got.Project != test.want.Project {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestProfileSettingKeepsVersions(t *testing.T) {
+	dir, cleanup := chdirTemp(t)
+	defer cleanup()
+	configPath := filepath.Join(dir, "config.yaml")
+	config := `
+profiles:
+  default:
+    settings:
+      ios: {model: iPhone 8, version: 14.0}
+      cross:
+        test_settings:
+          - {model: iPhone 8, version: 13.10}
+          - {model: iPhone X, version: "12.0"}
+        concurrency: 1
+      json: '{"model": "iPhone 8", "version": 14.0}'
+`
+	if err := ioutil.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadProfileFrom(configPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "ios", want: `{"model":"iPhone 8","version":"14.0"}`},
+		{name: "cross", want: `{"test_settings":[{"model":"iPhone 8","version":"13.10"},{"model":"iPhone X","version":"12.0"}],"concurrency":1}`},
+		{name: "json", want: `{"model": "iPhone 8", "version": 14.0}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setting, ok := p.Settings[test.name]
+			if !ok {
+				t.Fatalf("setting %s is not loaded", test.name)
+			}
+			got, ok := setting.value.(string)
+			if !ok {
+				if got, err = common.SettingToJSON(setting.value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var gotValue, wantValue interface{}
+			if err := json.Unmarshal([]byte(got), &gotValue); err != nil {
+				t.Fatal(err)
+			}
+			json.Unmarshal([]byte(test.want), &wantValue)
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -3,14 +3,9 @@ module github.com/Magic-Pod/magic-pod-api-client
 go 1.13
 
 require (
-	github.com/dsnet/compress v0.0.1 // indirect
 	github.com/go-resty/resty v0.0.0-00010101000000-000000000000
-	github.com/golang/snappy v0.0.1 // indirect
-	github.com/mholt/archiver v3.1.1+incompatible
-	github.com/nwaples/rardecode v1.0.0 // indirect
-	github.com/pierrec/lz4 v2.4.1+incompatible // indirect
 	github.com/urfave/cli v1.22.2
-	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 replace github.com/go-resty/resty => gopkg.in/resty.v1 v1.11.0
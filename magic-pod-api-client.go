@@ -1,25 +1,57 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/Magic-Pod/magic-pod-api-client/common"
 	"github.com/urfave/cli"
 )
 
+// exit codes other than 0 (succeeded), 1 (failed) and 2 (unresolved)
+const (
+	exitCodeTransportError = 3
+	exitCodeLocalIOError   = 4
+	exitCodeInterrupted    = 130 // same as shells report for SIGINT
+)
+
 func main() {
 	app := cli.NewApp()
 	app.Version = "0.65.0.1"
 	app.Name = "magic-pod-api-client"
 	app.Usage = "Simple and useful wrapper for Magic Pod Web API"
+	app.ExitErrHandler = handleExitError
 	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "output, O",
+			Value:  outputText,
+			Usage:  "Output format of the result. 'text', 'json' or 'yaml'",
+			EnvVar: "MAGIC_POD_OUTPUT",
+		},
+		cli.StringFlag{
+			Name:   "profile, P",
+			Usage:  "Profile name in the config file which provides token, organization, project, http headers and named settings",
+			EnvVar: "MAGIC_POD_PROFILE",
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "Path to the config file. If empty string is specified, the path will be ~/.config/magic-pod/config.yaml",
+			EnvVar: "MAGIC_POD_CONFIG",
+		},
 		// hidden option only for Magic Pod developers
 		cli.StringFlag{
 			Name:   "url-base",
-			Value:  "https://magic-pod.com",
+			Value:  common.DefaultURLBase,
 			Hidden: true,
 		},
 	}
@@ -36,6 +68,18 @@ func main() {
 					Name:  "setting, s",
 					Usage: "Test setting in JSON format. Please check https://magic-pod.com/api/v1.0/doc/ for more detail",
 				},
+				cli.StringFlag{
+					Name:  "setting_name, N",
+					Usage: "Name of the setting defined in the profile of the config file, instead of --setting",
+				},
+				cli.StringFlag{
+					Name:  "setting_file, f",
+					Usage: "Path to the test setting file in JSON or YAML format, instead of --setting",
+				},
+				cli.StringSliceFlag{
+					Name:  "var, V",
+					Usage: "Variable in key=value format to replace ${key} in the setting. Environment variables are also available. --setting and --setting_name are expanded only if this is specified",
+				},
 				cli.BoolFlag{
 					Name:  "no_wait, n",
 					Usage: "Return immediately without waiting the batch run to be finished",
@@ -44,9 +88,198 @@ func main() {
 					Name:  "wait_limit, w",
 					Usage: "Wait limit in seconds. If 0 is specified, the value is test count x 10 minutes",
 				},
+				cli.BoolFlag{
+					Name:  "cancel_on_interrupt",
+					Usage: "Stop the batch run on the server when this command is interrupted by SIGINT or SIGTERM",
+				},
+				cli.BoolFlag{
+					Name:  "skip_validation",
+					Usage: "Send the setting to the server without checking it locally",
+				},
+				cli.BoolFlag{
+					Name:  "strict",
+					Usage: "Reject keys unknown to this client instead of warning about them",
+				},
+				cli.StringFlag{
+					Name:  "junit_report, r",
+					Usage: "Path to write the result of each test case in JUnit XML format after the batch run is finished",
+				},
 			}...),
 			Action: batchRunAction,
 		},
+		{
+			Name:  "run-app",
+			Usage: "Upload app/ipa/apk file, run batch test for it, wait until the batch run is finished, and delete the file",
+			Flags: append(commonFlags(), []cli.Flag{
+				cli.StringFlag{
+					Name:  "app_path, a",
+					Usage: "Path to the app/ipa/apk file to upload",
+				},
+				cli.IntFlag{
+					Name:  "test_settings_number, S",
+					Usage: "Test settings number defined in the project batch run page",
+				},
+				cli.StringFlag{
+					Name:  "setting, s",
+					Usage: "Test setting in JSON format. app_file_number of every test setting is replaced with the uploaded file",
+				},
+				cli.StringFlag{
+					Name:  "setting_name, N",
+					Usage: "Name of the setting defined in the profile of the config file, instead of --setting",
+				},
+				cli.StringFlag{
+					Name:  "setting_file, f",
+					Usage: "Path to the test setting file in JSON or YAML format, instead of --setting",
+				},
+				cli.StringSliceFlag{
+					Name:  "var, V",
+					Usage: "Variable in key=value format to replace ${key} in the setting. Environment variables are also available. --setting and --setting_name are expanded only if this is specified",
+				},
+				cli.IntFlag{
+					Name:  "wait_limit, w",
+					Usage: "Wait limit in seconds. If 0 is specified, the value is test count x 10 minutes",
+				},
+				cli.StringFlag{
+					Name:  "delete_after",
+					Value: deleteAfterOnSuccess,
+					Usage: "When to delete the uploaded file. 'always', 'on_success' (all test cases succeeded) or 'never'",
+				},
+				cli.BoolFlag{
+					Name:  "cancel_on_interrupt",
+					Usage: "Stop the batch run on the server when this command is interrupted by SIGINT or SIGTERM",
+				},
+				cli.BoolFlag{
+					Name:  "skip_validation",
+					Usage: "Send the setting to the server without checking it locally",
+				},
+				cli.BoolFlag{
+					Name:  "strict",
+					Usage: "Reject keys unknown to this client instead of warning about them",
+				},
+				cli.StringFlag{
+					Name:  "junit_report, r",
+					Usage: "Path to write the result of each test case in JUnit XML format after the batch run is finished",
+				},
+			}...),
+			Action: runAppAction,
+		},
+		{
+			Name:  "rerun-failed",
+			Usage: "Rerun only failed and unresolved test cases of a finished batch run with the same devices",
+			Flags: append(commonFlags(), []cli.Flag{
+				cli.IntFlag{
+					Name:  "batch_run_number, b",
+					Usage: "Batch run number to rerun",
+				},
+				cli.IntFlag{
+					Name:  "test_settings_number, S",
+					Usage: "Test settings number used for the original batch run",
+				},
+				cli.StringFlag{
+					Name:  "setting, s",
+					Usage: "Test setting in JSON format used for the original batch run. Its values like app_file_number are applied to the rerun",
+				},
+				cli.StringFlag{
+					Name:  "setting_name, N",
+					Usage: "Name of the setting defined in the profile of the config file, instead of --setting",
+				},
+				cli.StringFlag{
+					Name:  "setting_file, f",
+					Usage: "Path to the test setting file in JSON or YAML format, instead of --setting",
+				},
+				cli.StringSliceFlag{
+					Name:  "var, V",
+					Usage: "Variable in key=value format to replace ${key} in the setting. Environment variables are also available. --setting and --setting_name are expanded only if this is specified",
+				},
+				cli.IntFlag{
+					Name:  "wait_limit, w",
+					Usage: "Wait limit in seconds. If 0 is specified, the value is test count x 10 minutes",
+				},
+				cli.BoolFlag{
+					Name:  "merge_results",
+					Usage: "Decide the exit code by the latest result of each test case in the original batch run and the rerun",
+				},
+				cli.BoolFlag{
+					Name:  "cancel_on_interrupt",
+					Usage: "Stop the batch run on the server when this command is interrupted by SIGINT or SIGTERM",
+				},
+			}...),
+			Action: rerunFailedAction,
+		},
+		{
+			Name:  "export-junit",
+			Usage: "Export the result of a finished batch run in JUnit XML format",
+			Flags: append(commonFlags(), []cli.Flag{
+				cli.IntFlag{
+					Name:  "batch_run_number, b",
+					Usage: "Batch run number",
+				},
+				cli.StringFlag{
+					Name:  "junit_report, r",
+					Value: "junit.xml",
+					Usage: "Path to write the JUnit XML report",
+				},
+			}...),
+			Action: exportJUnitAction,
+		},
+		{
+			Name:  "report",
+			Usage: "Create an HTML report of a finished batch run with its screenshots",
+			Flags: append(append(commonFlags(), screenshotTypeFlags()...), []cli.Flag{
+				cli.IntFlag{
+					Name:  "batch_run_number, b",
+					Usage: "Batch run number",
+				},
+				cli.StringFlag{
+					Name:  "html",
+					Value: "report",
+					Usage: "Directory to write index.html with embedded thumbnails and the full-size screenshots into",
+				},
+				cli.BoolFlag{
+					Name:  "skip_screenshots",
+					Usage: "Create the report without downloading screenshots",
+				},
+			}...),
+			Action: reportAction,
+		},
+		{
+			Name:  "get-batch-run",
+			Usage: "Show the result of each test case in a batch run",
+			Flags: append(commonFlags(), []cli.Flag{
+				cli.IntFlag{
+					Name:  "batch_run_number, b",
+					Usage: "Batch run number",
+				},
+			}...),
+			Action: getBatchRunAction,
+		},
+		{
+			Name:  "validate-setting",
+			Usage: "Check a batch run setting without running it",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "setting, s",
+					Usage: "Test setting in JSON format",
+				},
+				cli.StringFlag{
+					Name:  "setting_name, N",
+					Usage: "Name of the setting defined in the profile of the config file, instead of --setting",
+				},
+				cli.StringFlag{
+					Name:  "setting_file, f",
+					Usage: "Path to the test setting file in JSON or YAML format, instead of --setting",
+				},
+				cli.StringSliceFlag{
+					Name:  "var, V",
+					Usage: "Variable in key=value format to replace ${key} in the setting. Environment variables are also available. --setting and --setting_name are expanded only if this is specified",
+				},
+				cli.BoolFlag{
+					Name:  "strict",
+					Usage: "Reject keys unknown to this client instead of warning about them",
+				},
+			},
+			Action: validateSettingAction,
+		},
 		{
 			Name:   "latest-batch-run-no",
 			Usage:  "Get the latest batch run number",
@@ -55,11 +288,20 @@ func main() {
 		},
 		{
 			Name:  "upload-app",
-			Usage: "Upload app/ipa/apk file",
+			Usage: "Upload app/ipa/apk/aab files",
 			Flags: append(commonFlags(), []cli.Flag{
-				cli.StringFlag{
+				cli.StringSliceFlag{
 					Name:  "app_path, a",
-					Usage: "Path to the app/ipa/apk file to upload",
+					Usage: "Path to the app/ipa/apk/aab/zip file to upload. Can be specified multiple times",
+				},
+				cli.IntFlag{
+					Name:  "parallel",
+					Value: 3,
+					Usage: "Number of files uploaded at the same time",
+				},
+				cli.BoolFlag{
+					Name:  "dedupe",
+					Usage: "Return the file number uploaded before instead of uploading again if the identical file was uploaded from this machine",
 				},
 			}...),
 			Action: uploadAppAction,
@@ -75,10 +317,39 @@ func main() {
 			}...),
 			Action: deleteAppAction,
 		},
+		{
+			Name:   "list-apps",
+			Usage:  "List app/ipa/apk files uploaded to the project",
+			Flags:  commonFlags(),
+			Action: listAppsAction,
+		},
+		{
+			Name:  "prune-apps",
+			Usage: "Delete old app/ipa/apk files uploaded to the project",
+			Flags: append(commonFlags(), []cli.Flag{
+				cli.StringFlag{
+					Name:  "older_than",
+					Usage: "Delete files uploaded before this period like '7d', '2w' or '12h'",
+				},
+				cli.IntFlag{
+					Name:  "keep_last",
+					Usage: "Keep this number of the newest files",
+				},
+				cli.StringFlag{
+					Name:  "name_pattern",
+					Usage: "Only files whose name matches this glob pattern like '*.apk' are deleted",
+				},
+				cli.BoolFlag{
+					Name:  "dry_run",
+					Usage: "Show the files to be deleted without deleting them",
+				},
+			}...),
+			Action: pruneAppsAction,
+		},
 		{
 			Name:  "get-screenshots",
 			Usage: "Download screenshots for a batch run",
-			Flags: append(commonFlags(), []cli.Flag{
+			Flags: append(append(commonFlags(), screenshotTypeFlags()...), []cli.Flag{
 				cli.IntFlag{
 					Name:  "batch_run_number, b",
 					Usage: "Batch run number",
@@ -88,82 +359,174 @@ func main() {
 					Usage: "Download destination file path. If empty string is speficied, the path will be ./screenshots.zip",
 				},
 				cli.StringFlag{
-					Name:  "file_index_type, i",
-					Usage: "'line_number' or 'auto_increment'. If empty string is specified, the type will be 'line_number'",
+					Name:  "extract_to, x",
+					Usage: "Directory to extract the downloaded zip file into. manifest.json listing the screenshots is also written there",
 				},
 				cli.StringFlag{
-					Name:  "file_name_body_type, B",
-					Usage: "'none' or 'screenshot_name'. If empty string is specified, the type will be 'none'",
+					Name:  "layout",
+					Value: common.ScreenshotLayoutAsIs,
+					Usage: "Directory layout of the extracted files. 'as_is', 'test_case' (test case/test setting) or 'device' (test setting/test case)",
 				},
-				cli.StringFlag{
-					Name:  "download_type, D",
-					Usage: "'all' or 'command_only' (i.e. screenshots only for 'Take screenshot' command). If empty string is specified, the type will be 'all'",
+			}...),
+			Action: getScrenshotsAction,
+		},
+		{
+			Name:  "compare-screenshots",
+			Usage: "Compare screenshots of two batch runs and fail if they differ",
+			Flags: append(append(commonFlags(), screenshotTypeFlags()...), []cli.Flag{
+				cli.IntFlag{
+					Name:  "batch_run_number, b",
+					Usage: "Batch run number of the base screenshots",
 				},
-				cli.BoolFlag{
-					Name:  "mask_dynamically_changed_area, m",
-					Usage: "Mask dynamically changed areas which can cause unexpected image difference between each test",
+				cli.IntFlag{
+					Name:  "target_batch_run_number, b2",
+					Usage: "Batch run number of the screenshots compared with the base",
+				},
+				cli.Float64Flag{
+					Name:  "threshold",
+					Usage: "Percentage of different pixels tolerated in each screenshot",
+				},
+				cli.IntFlag{
+					Name:  "color_tolerance",
+					Usage: "Difference of each color channel (0 - 255) regarded as the same pixel",
+				},
+				cli.StringFlag{
+					Name:  "diff_dir",
+					Value: "screenshot-diff",
+					Usage: "Directory to write images which show the different pixels in red",
 				},
 			}...),
-			Action: getScrenshotsAction,
+			Action: compareScreenshotsAction,
+		},
+		{
+			Name:  "screenshots",
+			Usage: "Manage approved screenshots as a baseline and check batch runs against it",
+			Subcommands: []cli.Command{
+				{
+					Name:  "approve",
+					Usage: "Save screenshots of a batch run as a new version of the baseline",
+					Flags: append(append(commonFlags(), baselineFlags()...), []cli.Flag{
+						cli.IntFlag{
+							Name:  "batch_run_number, b",
+							Usage: "Batch run number whose screenshots are approved",
+						},
+					}...),
+					Action: approveScreenshotsAction,
+				},
+				{
+					Name:  "check",
+					Usage: "Compare screenshots of a batch run with the baseline and fail if they differ",
+					Flags: append(append(commonFlags(), baselineFlags()...), []cli.Flag{
+						cli.IntFlag{
+							Name:  "batch_run_number, b",
+							Usage: "Batch run number to check",
+						},
+						cli.StringFlag{
+							Name:  "version",
+							Usage: "Baseline version like 'v3' to compare with. If empty string is specified, the latest version is used",
+						},
+						cli.Float64Flag{
+							Name:  "threshold",
+							Usage: "Percentage of different pixels tolerated in each screenshot",
+						},
+						cli.IntFlag{
+							Name:  "color_tolerance",
+							Usage: "Difference of each color channel (0 - 255) regarded as the same pixel",
+						},
+						cli.StringFlag{
+							Name:  "diff_dir",
+							Value: "screenshot-diff",
+							Usage: "Directory to write images which show the different pixels in red",
+						},
+					}...),
+					Action: checkScreenshotsAction,
+				},
+			},
 		},
 	}
 	app.Run(os.Args)
 }
 
-func latestBatchRunNoAction(c *cli.Context) error {
-	// handle command line arguments
-	urlBase, apiToken, organization, project, httpHeadersMap, err := parseCommonFlags(c)
-	if err != nil {
-		return err
+// handleExitError converts errors returned from common package into exit codes
+func handleExitError(c *cli.Context, err error) {
+	if err == nil {
+		return
 	}
+	if _, ok := err.(cli.ExitCoder); ok {
+		cli.HandleExitCoder(err)
+	} else {
+		cli.HandleExitCoder(cli.NewExitError(err.Error(), exitCodeOf(err)))
+	}
+}
 
-	batchRunNo, exitErr := common.LatestBatchRunNo(urlBase, apiToken, organization, project, httpHeadersMap)
-	if exitErr != nil {
-		return exitErr
+// exitCodeOf maps errors returned by the common package to exit codes
+func exitCodeOf(err error) int {
+	var transportErr *common.TransportError
+	var localIOErr *common.LocalIOError
+	switch {
+	case errors.As(err, &transportErr):
+		return exitCodeTransportError
+	case errors.As(err, &localIOErr):
+		return exitCodeLocalIOError
+	default:
+		// the other errors, e.g. *common.APIError and *common.SettingError, are reported as failures
+		return 1
 	}
-	fmt.Printf("%d\n", batchRunNo)
-	return nil
 }
 
-func uploadAppAction(c *cli.Context) error {
+func reportAction(c *cli.Context) error {
 	// handle command line arguments
-	urlBase, apiToken, organization, project, httpHeadersMap, err := parseCommonFlags(c)
+	client, err := createClient(c)
 	if err != nil {
 		return err
 	}
-	appPath := c.String("app_path")
-	if appPath == "" {
-		return cli.NewExitError("--app_path option is required", 1)
+	batchRunNumber := c.Int("batch_run_number")
+	if batchRunNumber == 0 {
+		return cli.NewExitError("--batch_run_number option is not specified or 0", 1)
 	}
-
-	fileNo, exitErr := common.UploadApp(urlBase, apiToken, organization, project, httpHeadersMap, appPath)
-	if exitErr != nil {
-		return exitErr
+	reportDir := c.String("html")
+	if reportDir == "" {
+		return cli.NewExitError("--html option cannot be empty", 1)
 	}
-	fmt.Printf("%d\n", fileNo)
-	return nil
-}
+	fileIndexType, fileNameBodyType, downloadType, maskDynamicallyChangedArea := parseScreenshotTypeFlags(c)
 
-func deleteAppAction(c *cli.Context) error {
-	// handle command line arguments
-	urlBase, apiToken, organization, project, httpHeadersMap, err := parseCommonFlags(c)
+	ctx, interrupted := interruptibleContext()
+	batchRun, err := client.GetBatchRunContext(ctx, batchRunNumber)
+	if interrupted() {
+		return cli.NewExitError("\ninterrupted", exitCodeInterrupted)
+	}
 	if err != nil {
 		return err
 	}
-	appFileNumber := c.Int("app_file_number")
-	if appFileNumber == 0 {
-		return cli.NewExitError("--app_file_number option is not specified or 0", 1)
+	if batchRun.Status == "running" {
+		return cli.NewExitError(fmt.Sprintf("batch run #%d has not finished yet", batchRunNumber), 1)
 	}
-	exitErr := common.DeleteApp(urlBase, apiToken, organization, project, httpHeadersMap, appFileNumber)
-	if exitErr != nil {
-		return exitErr
+	if err := os.MkdirAll(reportDir, 0755); err != nil {
+		return &common.LocalIOError{Path: reportDir, Err: err}
 	}
-	return nil
+	const screenshotDir = "screenshots"
+	var screenshots []common.Screenshot
+	if !c.Bool("skip_screenshots") {
+		screenshots, err = client.DownloadScreenshotsContext(ctx, batchRunNumber, filepath.Join(reportDir, screenshotDir),
+			fileIndexType, fileNameBodyType, downloadType, maskDynamicallyChangedArea, common.ScreenshotLayoutAsIs)
+		if interrupted() {
+			return cli.NewExitError("\ninterrupted", exitCodeInterrupted)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	reportPath := filepath.Join(reportDir, "index.html")
+	if err := common.SaveHTMLReport(batchRun, screenshots, screenshotDir, reportPath); err != nil {
+		return err
+	}
+	output := &htmlReportOutput{BatchRunNumber: batchRunNumber, ReportPath: reportPath, Screenshots: len(screenshots)}
+	return printOutput(c, fmt.Sprintf("%s\n", reportPath), output)
 }
 
-func getScrenshotsAction(c *cli.Context) error {
+func getBatchRunAction(c *cli.Context) error {
 	// handle command line arguments
-	urlBase, apiToken, organization, project, httpHeadersMap, err := parseCommonFlags(c)
+	client, err := createClient(c)
 	if err != nil {
 		return err
 	}
@@ -171,66 +534,812 @@ func getScrenshotsAction(c *cli.Context) error {
 	if batchRunNumber == 0 {
 		return cli.NewExitError("--batch_run_number option is not specified or 0", 1)
 	}
-	downloadPath := c.String("download_path")
-	if downloadPath == "" {
-		curDir, err := os.Getwd()
-		if err != nil {
-			panic(err)
-		}
-		downloadPath = filepath.Join(curDir, "screenshots.zip")
-	} else {
-		stat, err := os.Stat(downloadPath)
-		if err == nil {
-			// downloadPath already exists
-			mode := stat.Mode()
-			if mode.IsDir() {
-				return cli.NewExitError(fmt.Sprintf("'%s' should be not a directory but a file", downloadPath), 1)
-			}
-		}
+
+	batchRun, err := client.GetBatchRun(batchRunNumber)
+	if err != nil {
+		return err
 	}
-	downloadPath, err = filepath.Abs(downloadPath)
+	return printOutput(c, batchRunText(batchRun), newBatchRunOutput(batchRun))
+}
+
+func validateSettingAction(c *cli.Context) error {
+	setting, err := settingFromFlags(c)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	fileIndexType := c.String("file_index_type")
-	if fileIndexType == "" {
-		fileIndexType = "line_number"
+	if setting == "" {
+		return cli.NewExitError("Either of --setting, --setting_name or --setting_file option is required", 1)
 	}
-	fileNameBodyType := c.String("file_name_body_type")
-	if fileNameBodyType == "" {
-		fileNameBodyType = "none"
+	if err := validateSetting(c, setting); err != nil {
+		return err
 	}
-	downloadType := c.String("download_type")
-	if downloadType == "" {
-		downloadType = "all"
+	output, err := newSettingOutput(setting)
+	if err != nil {
+		return err
 	}
-	maskDynamicallyChangedArea := c.Bool("mask_dynamically_changed_area")
-	exitErr := common.GetScreenshots(urlBase, apiToken, organization, project, httpHeadersMap, batchRunNumber, downloadPath, fileIndexType, fileNameBodyType, downloadType, maskDynamicallyChangedArea)
-	if exitErr != nil {
-		return exitErr
+	return printOutput(c, "setting is valid\n", output)
+}
+
+// validateSetting checks the setting locally. Unknown keys are printed as warnings unless --strict is specified
+func validateSetting(c *cli.Context, setting string) error {
+	warnings, err := common.ValidateSetting(setting)
+	if len(warnings) > 0 && c.Bool("strict") {
+		problems := warnings
+		if validationErr, ok := err.(*common.SettingValidationError); ok {
+			problems = append(problems, validationErr.Problems...)
+		}
+		return cli.NewExitError((&common.SettingValidationError{Problems: problems}).Error(), 1)
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s: %s\n", warning.Path, warning.Message)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
 	}
 	return nil
 }
 
-func batchRunAction(c *cli.Context) error {
+func latestBatchRunNoAction(c *cli.Context) error {
 	// handle command line arguments
-	urlBase, apiToken, organization, project, httpHeadersMap, err := parseCommonFlags(c)
+	client, err := createClient(c)
 	if err != nil {
 		return err
 	}
-	testSettingsNumber := c.Int("test_settings_number")
-	setting := c.String("setting")
-	if testSettingsNumber == 0 && setting == "" {
-		return cli.NewExitError("Either of --test_settings_number or --setting option is required", 1)
-	}
-	noWait := c.Bool("no_wait")
-	waitLimit := c.Int("wait_limit")
 
-	_, existsErr, existsUnresolved, batchRunError := common.ExecuteBatchRun(urlBase, apiToken, organization,
-		project, httpHeadersMap, testSettingsNumber, setting, !noWait, waitLimit, true)
-	if batchRunError != nil {
+	batchRunNo, exitErr := client.LatestBatchRunNo()
+	if exitErr != nil {
+		return exitErr
+	}
+	return printOutput(c, fmt.Sprintf("%d\n", batchRunNo), &batchRunNumberOutput{BatchRunNumber: batchRunNo})
+}
+
+func uploadAppAction(c *cli.Context) error {
+	// handle command line arguments
+	client, err := createClient(c)
+	if err != nil {
+		return err
+	}
+	appPaths := c.StringSlice("app_path")
+	if len(appPaths) == 0 {
+		return cli.NewExitError("--app_path option is required", 1)
+	}
+	// check all files before uploading any of them
+	for _, appPath := range appPaths {
+		if err := common.ValidateAppPath(appPath); err != nil {
+			return err
+		}
+	}
+	parallel := c.Int("parallel")
+	if parallel < 1 {
+		return cli.NewExitError("--parallel must be 1 or more", 1)
+	}
+	upload := func(ctx context.Context, appPath string) (int, bool, error) {
+		fileNo, err := client.UploadAppContext(ctx, appPath)
+		return fileNo, false, err
+	}
+	if c.Bool("dedupe") {
+		if common.DefaultUploadCachePath() == "" {
+			return cli.NewExitError("--dedupe is not available since the user cache directory is unknown", 1)
+		}
+		upload = client.UploadAppDeduplicatedContext
+	}
+
+	outputs, err := uploadApps(appPaths, parallel, upload)
+	if err != nil {
+		// tell the files uploaded before the failure so that they can be deleted
+		for _, output := range outputs {
+			if output.AppFileNumber != 0 {
+				fmt.Fprintf(os.Stderr, "%s is uploaded as app file #%d\n", output.AppPath, output.AppFileNumber)
+			}
+		}
+		return err
+	}
+	text := ""
+	for _, output := range outputs {
+		text += fmt.Sprintf("%d\n", output.AppFileNumber)
+	}
+	if len(outputs) == 1 {
+		return printOutput(c, text, &outputs[0])
+	}
+	return printOutput(c, text, &uploadedAppsOutput{AppFiles: outputs})
+}
+
+// uploadApps uploads files with at most parallel goroutines, and returns the results in the order of appPaths.
+// When one of the uploads fails, the others are canceled and the results of the finished ones are returned with the error
+func uploadApps(appPaths []string, parallel int, upload func(ctx context.Context, appPath string) (int, bool, error)) ([]appFileOutput, error) {
+	ctx, interrupted := interruptibleContext()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	outputs := make([]appFileOutput, len(appPaths))
+	errs := make([]error, len(appPaths))
+	semaphore := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, appPath := range appPaths {
+		wg.Add(1)
+		go func(i int, appPath string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			if ctx.Err() != nil {
+				return
+			}
+			fileNo, reused, err := upload(ctx, appPath)
+			if err != nil {
+				errs[i] = err
+				cancel()
+				return
+			}
+			outputs[i] = appFileOutput{AppFileNumber: fileNo, AppPath: appPath, Reused: reused}
+		}(i, appPath)
+	}
+	wg.Wait()
+	if interrupted() {
+		return outputs, cli.NewExitError("\ninterrupted", exitCodeInterrupted)
+	}
+	for i, err := range errs {
+		// errors of the other uploads are caused by the cancellation
+		if err != nil && (ctx.Err() == nil || !errors.Is(err, context.Canceled)) {
+			if len(appPaths) > 1 {
+				fmt.Fprintf(os.Stderr, "failed to upload %s\n", appPaths[i])
+			}
+			return outputs, err
+		}
+	}
+	return outputs, nil
+}
+
+func deleteAppAction(c *cli.Context) error {
+	// handle command line arguments
+	client, err := createClient(c)
+	if err != nil {
+		return err
+	}
+	appFileNumber := c.Int("app_file_number")
+	if appFileNumber == 0 {
+		return cli.NewExitError("--app_file_number option is not specified or 0", 1)
+	}
+	exitErr := client.DeleteApp(appFileNumber)
+	if exitErr != nil {
+		return exitErr
+	}
+	return printOutput(c, "", &appFileOutput{AppFileNumber: appFileNumber, Deleted: true})
+}
+
+func listAppsAction(c *cli.Context) error {
+	// handle command line arguments
+	client, err := createClient(c)
+	if err != nil {
+		return err
+	}
+
+	appFiles, err := client.ListApps()
+	if err != nil {
+		return err
+	}
+	return printOutput(c, appFilesText(appFiles), newAppFilesOutput(appFiles))
+}
+
+func pruneAppsAction(c *cli.Context) error {
+	// handle command line arguments
+	client, err := createClient(c)
+	if err != nil {
+		return err
+	}
+	olderThan, err := parsePeriod(c.String("older_than"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("--older_than is invalid: %s", err), 1)
+	}
+	keepLast := c.Int("keep_last")
+	if keepLast < 0 {
+		return cli.NewExitError("--keep_last must not be negative", 1)
+	}
+	if olderThan == 0 && keepLast == 0 {
+		return cli.NewExitError("Either of --older_than or --keep_last option is required", 1)
+	}
+	rule := common.PruneRule{OlderThan: olderThan, KeepLast: keepLast, NamePattern: c.String("name_pattern")}
+	dryRun := c.Bool("dry_run")
+
+	appFiles, err := client.ListApps()
+	if err != nil {
+		return err
+	}
+	targets, err := rule.Select(appFiles, time.Now())
+	if err != nil {
+		return err
+	}
+	output := &pruneAppsOutput{DryRun: dryRun, AppFiles: []uploadedAppFileOutput{}}
+	text := ""
+	var deleteErr error
+	for _, appFile := range targets {
+		if !dryRun {
+			if err := client.DeleteApp(appFile.File_No); err != nil {
+				// continue to delete the rest, and report the first error at the end
+				fmt.Fprintf(os.Stderr, "failed to delete #%d %s: %s\n", appFile.File_No, appFile.File_Name, err)
+				if deleteErr == nil {
+					deleteErr = err
+				}
+				continue
+			}
+		}
+		output.AppFiles = append(output.AppFiles, newAppFilesOutput([]common.AppFile{appFile}).AppFiles...)
+		if dryRun {
+			text += fmt.Sprintf("would delete #%d %s\n", appFile.File_No, appFile.File_Name)
+		} else {
+			text += fmt.Sprintf("deleted #%d %s\n", appFile.File_No, appFile.File_Name)
+		}
+	}
+	if len(targets) == 0 {
+		text = "no app file to delete\n"
+	}
+	if err := printOutput(c, text, output); err != nil {
+		return err
+	}
+	return deleteErr
+}
+
+// parsePeriod parses a period like "7d" and "2w" in addition to the format of time.ParseDuration.
+// An empty string means 0
+func parsePeriod(period string) (time.Duration, error) {
+	if period == "" {
+		return 0, nil
+	}
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if unit, ok := units[period[len(period)-1]]; ok {
+		count, err := strconv.Atoi(period[:len(period)-1])
+		if err != nil || count < 0 {
+			return 0, fmt.Errorf("%q is not a valid period", period)
+		}
+		return time.Duration(count) * unit, nil
+	}
+	duration, err := time.ParseDuration(period)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("%q is not a valid period", period)
+	}
+	return duration, nil
+}
+
+func getScrenshotsAction(c *cli.Context) error {
+	// handle command line arguments
+	client, err := createClient(c)
+	if err != nil {
+		return err
+	}
+	batchRunNumber := c.Int("batch_run_number")
+	if batchRunNumber == 0 {
+		return cli.NewExitError("--batch_run_number option is not specified or 0", 1)
+	}
+	downloadPath := c.String("download_path")
+	if downloadPath == "" {
+		curDir, err := os.Getwd()
+		if err != nil {
+			return &common.LocalIOError{Path: ".", Err: err}
+		}
+		downloadPath = filepath.Join(curDir, "screenshots.zip")
+	} else {
+		stat, err := os.Stat(downloadPath)
+		if err == nil {
+			// downloadPath already exists
+			mode := stat.Mode()
+			if mode.IsDir() {
+				return cli.NewExitError(fmt.Sprintf("'%s' should be not a directory but a file", downloadPath), 1)
+			}
+		}
+	}
+	downloadPath, err = filepath.Abs(downloadPath)
+	if err != nil {
+		return &common.LocalIOError{Path: downloadPath, Err: err}
+	}
+	fileIndexType, fileNameBodyType, downloadType, maskDynamicallyChangedArea := parseScreenshotTypeFlags(c)
+	extractTo := c.String("extract_to")
+	layout := c.String("layout")
+	switch layout {
+	case common.ScreenshotLayoutAsIs, common.ScreenshotLayoutTestCase, common.ScreenshotLayoutDevice:
+	default:
+		return cli.NewExitError(fmt.Sprintf("--layout must be '%s', '%s' or '%s'",
+			common.ScreenshotLayoutAsIs, common.ScreenshotLayoutTestCase, common.ScreenshotLayoutDevice), 1)
+	}
+	exitErr := client.GetScreenshots(batchRunNumber, downloadPath, fileIndexType, fileNameBodyType, downloadType, maskDynamicallyChangedArea)
+	if exitErr != nil {
+		return exitErr
+	}
+	output := &screenshotsOutput{BatchRunNumber: batchRunNumber, DownloadPath: downloadPath}
+	if extractTo == "" {
+		return printOutput(c, "", output)
+	}
+
+	screenshots, err := common.ExtractScreenshots(downloadPath, extractTo, fileIndexType, fileNameBodyType, layout)
+	if err != nil {
+		return err
+	}
+	manifest := &common.ScreenshotManifest{
+		Batch_Run_Number:    batchRunNumber,
+		File_Index_Type:     fileIndexType,
+		File_Name_Body_Type: fileNameBodyType,
+		Screenshots:         screenshots,
+	}
+	manifestPath := filepath.Join(extractTo, "manifest.json")
+	if err := common.SaveScreenshotManifest(manifest, manifestPath); err != nil {
+		return err
+	}
+	output.ExtractedTo = extractTo
+	output.ManifestPath = manifestPath
+	output.Screenshots = len(screenshots)
+	text := fmt.Sprintf("extracted %d screenshots to %s\n", len(screenshots), extractTo)
+	return printOutput(c, text, output)
+}
+
+func compareScreenshotsAction(c *cli.Context) error {
+	// handle command line arguments
+	client, err := createClient(c)
+	if err != nil {
+		return err
+	}
+	baseNumber := c.Int("batch_run_number")
+	targetNumber := c.Int("target_batch_run_number")
+	if baseNumber == 0 || targetNumber == 0 {
+		return cli.NewExitError("--batch_run_number and --target_batch_run_number options are required", 1)
+	}
+	fileIndexType, fileNameBodyType, downloadType, maskDynamicallyChangedArea := parseScreenshotTypeFlags(c)
+	option := common.CompareOption{
+		Threshold:      c.Float64("threshold"),
+		ColorTolerance: c.Int("color_tolerance"),
+		DiffDir:        c.String("diff_dir"),
+	}
+
+	tmpDir, err := ioutil.TempDir("", "magic-pod-compare-")
+	if err != nil {
+		return &common.LocalIOError{Path: os.TempDir(), Err: err}
+	}
+	defer os.RemoveAll(tmpDir)
+	ctx, interrupted := interruptibleContext()
+	extracted := make(map[int][]common.Screenshot)
+	for _, batchRunNumber := range []int{baseNumber, targetNumber} {
+		screenshots, err := client.DownloadScreenshotsContext(ctx, batchRunNumber, filepath.Join(tmpDir, strconv.Itoa(batchRunNumber)),
+			fileIndexType, fileNameBodyType, downloadType, maskDynamicallyChangedArea, common.ScreenshotLayoutAsIs)
+		if interrupted() {
+			return cli.NewExitError("\ninterrupted", exitCodeInterrupted)
+		}
+		if err != nil {
+			return err
+		}
+		extracted[batchRunNumber] = screenshots
+	}
+	diffs, err := common.CompareScreenshots(
+		filepath.Join(tmpDir, strconv.Itoa(baseNumber)), extracted[baseNumber],
+		filepath.Join(tmpDir, strconv.Itoa(targetNumber)), extracted[targetNumber], option)
+	if err != nil {
+		return err
+	}
+
+	output := newCompareOutput(baseNumber, targetNumber, diffs)
+	if err := printOutput(c, compareText(output), output); err != nil {
+		return err
+	}
+	if output.Failed > 0 {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
+
+func approveScreenshotsAction(c *cli.Context) error {
+	// handle command line arguments
+	client, err := createClient(c)
+	if err != nil {
+		return err
+	}
+	batchRunNumber := c.Int("batch_run_number")
+	if batchRunNumber == 0 {
+		return cli.NewExitError("--batch_run_number option is not specified or 0", 1)
+	}
+	baselineDir := c.String("baseline_dir")
+	if baselineDir == "" {
+		return cli.NewExitError("--baseline_dir option cannot be empty", 1)
+	}
+
+	if err := os.MkdirAll(baselineDir, 0755); err != nil {
+		return &common.LocalIOError{Path: baselineDir, Err: err}
+	}
+	// extract into baselineDir so that the directory can be renamed to the version at once
+	tmpDir, err := ioutil.TempDir(baselineDir, ".approving-")
+	if err != nil {
+		return &common.LocalIOError{Path: baselineDir, Err: err}
+	}
+	defer os.RemoveAll(tmpDir)
+	ctx, interrupted := interruptibleContext()
+	screenshots, err := client.DownloadScreenshotsContext(ctx, batchRunNumber, tmpDir,
+		common.BaselineFileIndexType, common.BaselineFileNameBodyType, c.String("download_type"), c.Bool("mask_dynamically_changed_area"),
+		common.ScreenshotLayoutAsIs)
+	if interrupted() {
+		return cli.NewExitError("\ninterrupted", exitCodeInterrupted)
+	}
+	if err != nil {
+		return err
+	}
+	manifest := &common.ScreenshotManifest{
+		Batch_Run_Number:    batchRunNumber,
+		File_Index_Type:     common.BaselineFileIndexType,
+		File_Name_Body_Type: common.BaselineFileNameBodyType,
+		Screenshots:         screenshots,
+	}
+	version, err := common.ApproveBaseline(baselineDir, tmpDir, manifest)
+	if err != nil {
+		return err
+	}
+	output := &baselineOutput{
+		BatchRunNumber: batchRunNumber,
+		Version:        version,
+		Path:           filepath.Join(baselineDir, version),
+		Screenshots:    len(screenshots),
+	}
+	text := fmt.Sprintf("approved %d screenshots of batch run #%d as %s\n", len(screenshots), batchRunNumber, output.Path)
+	return printOutput(c, text, output)
+}
+
+func checkScreenshotsAction(c *cli.Context) error {
+	// handle command line arguments
+	client, err := createClient(c)
+	if err != nil {
+		return err
+	}
+	batchRunNumber := c.Int("batch_run_number")
+	if batchRunNumber == 0 {
+		return cli.NewExitError("--batch_run_number option is not specified or 0", 1)
+	}
+	option := common.CompareOption{
+		Threshold:      c.Float64("threshold"),
+		ColorTolerance: c.Int("color_tolerance"),
+		DiffDir:        c.String("diff_dir"),
+	}
+
+	manifest, versionDir, err := common.LoadBaseline(c.String("baseline_dir"), c.String("version"))
+	if err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir("", "magic-pod-check-")
+	if err != nil {
+		return &common.LocalIOError{Path: os.TempDir(), Err: err}
+	}
+	defer os.RemoveAll(tmpDir)
+	ctx, interrupted := interruptibleContext()
+	screenshots, err := client.DownloadScreenshotsContext(ctx, batchRunNumber, tmpDir,
+		manifest.File_Index_Type, manifest.File_Name_Body_Type, c.String("download_type"), c.Bool("mask_dynamically_changed_area"),
+		common.ScreenshotLayoutAsIs)
+	if interrupted() {
+		return cli.NewExitError("\ninterrupted", exitCodeInterrupted)
+	}
+	if err != nil {
+		return err
+	}
+	diffs, err := common.CompareScreenshots(versionDir, manifest.Screenshots, tmpDir, screenshots, option)
+	if err != nil {
+		return err
+	}
+
+	output := newCompareOutput(manifest.Batch_Run_Number, batchRunNumber, diffs)
+	output.BaselineVersion = filepath.Base(versionDir)
+	if err := printOutput(c, compareText(output), output); err != nil {
+		return err
+	}
+	if output.Failed > 0 {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
+
+func batchRunAction(c *cli.Context) error {
+	// handle command line arguments
+	client, err := createClient(c)
+	if err != nil {
+		return err
+	}
+	testSettingsNumber := c.Int("test_settings_number")
+	setting, err := settingFromFlags(c)
+	if err != nil {
+		return err
+	}
+	if testSettingsNumber == 0 && setting == "" {
+		return cli.NewExitError("Either of --test_settings_number, --setting, --setting_name or --setting_file option is required", 1)
+	}
+	if setting != "" && !c.Bool("skip_validation") {
+		if err := validateSetting(c, setting); err != nil {
+			return err
+		}
+	}
+	noWait := c.Bool("no_wait")
+	waitLimit := c.Int("wait_limit")
+	cancelOnInterrupt := c.Bool("cancel_on_interrupt")
+	junitReport := c.String("junit_report")
+	if noWait && junitReport != "" {
+		return cli.NewExitError("--junit_report cannot be used with --no_wait", 1)
+	}
+
+	ctx, interrupted := interruptibleContext()
+	startTime := time.Now()
+	batchRun, existsErr, existsUnresolved, batchRunError := client.ExecuteBatchRunContext(ctx, testSettingsNumber, setting, !noWait, waitLimit, isTextOutput(c))
+	if interrupted() {
+		return stopInterruptedBatchRun(client, batchRun, cancelOnInterrupt, nil)
+	}
+	if batchRunError != nil {
+		return batchRunError
+	}
+	duration := time.Since(startTime)
+	if !noWait && (junitReport != "" || !isTextOutput(c)) {
+		// batchRun is the state when it started, so retrieve the final state
+		batchRun, err = client.GetBatchRunContext(ctx, batchRun.Batch_Run_Number)
+		if err != nil {
+			return err
+		}
+	}
+	if junitReport != "" {
+		if err := common.SaveJUnitReport(batchRun, junitReport); err != nil {
+			return err
+		}
+	}
+	if !isTextOutput(c) {
+		if err := printBatchRunOutput(c, batchRun, !noWait, duration); err != nil {
+			return err
+		}
+	}
+	if existsErr {
+		return cli.NewExitError("", 1)
+	}
+	if existsUnresolved {
+		return cli.NewExitError("", 2)
+	}
+	return nil
+}
+
+// printBatchRunOutput prints the state of the batch run in JSON or YAML
+func printBatchRunOutput(c *cli.Context, batchRun *common.BatchRun, waited bool, duration time.Duration) error {
+	output := newBatchRunOutput(batchRun)
+	if waited {
+		durationSeconds := int(duration.Seconds())
+		output.DurationSeconds = &durationSeconds
+	}
+	return printOutput(c, "", output)
+}
+
+// interruptibleContext returns a context which is canceled by SIGINT or SIGTERM,
+// and a function which reports whether the signal has been received.
+// Only the first signal is handled, so that a second one kills the process even while stopping the batch run
+func interruptibleContext() (context.Context, func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx, func() bool {
+		return ctx.Err() != nil
+	}
+}
+
+// stopInterruptedBatchRun stops the batch run if cancelOnInterrupt is true, and returns the error for the interruption.
+// afterStop is called if not nil when the batch run is not running any more, i.e. it was not started or has been stopped
+func stopInterruptedBatchRun(client *common.Client, batchRun *common.BatchRun, cancelOnInterrupt bool, afterStop func()) error {
+	if batchRun == nil && afterStop != nil {
+		afterStop()
+	}
+	if batchRun == nil || !cancelOnInterrupt {
+		return cli.NewExitError("\ninterrupted", exitCodeInterrupted)
+	}
+	// the original context is already canceled, so the stop request needs a new one
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := client.StopBatchRunContext(ctx, batchRun.Batch_Run_Number); err != nil {
+		return cli.NewExitError(fmt.Sprintf("\ninterrupted, but failed to stop batch run #%d: %s", batchRun.Batch_Run_Number, err), exitCodeInterrupted)
+	}
+	if afterStop != nil {
+		afterStop()
+	}
+	return cli.NewExitError(fmt.Sprintf("\ninterrupted, and batch run #%d was stopped", batchRun.Batch_Run_Number), exitCodeInterrupted)
+}
+
+const (
+	deleteAfterAlways    = "always"
+	deleteAfterOnSuccess = "on_success"
+	deleteAfterNever     = "never"
+)
+
+func runAppAction(c *cli.Context) error {
+	// handle command line arguments
+	client, err := createClient(c)
+	if err != nil {
+		return err
+	}
+	appPath := c.String("app_path")
+	if appPath == "" {
+		return cli.NewExitError("--app_path option is required", 1)
+	}
+	testSettingsNumber := c.Int("test_settings_number")
+	setting, err := settingFromFlags(c)
+	if err != nil {
+		return err
+	}
+	if testSettingsNumber == 0 && setting == "" {
+		return cli.NewExitError("Either of --test_settings_number, --setting, --setting_name or --setting_file option is required", 1)
+	}
+	if setting != "" && !c.Bool("skip_validation") {
+		if err := validateSetting(c, setting); err != nil {
+			return err
+		}
+	}
+	runSetting, err := common.ParseRunSetting(testSettingsNumber, setting)
+	if err != nil {
+		return err
+	}
+	deleteAfter := c.String("delete_after")
+	switch deleteAfter {
+	case deleteAfterAlways, deleteAfterOnSuccess, deleteAfterNever:
+	default:
+		return cli.NewExitError(fmt.Sprintf("--delete_after must be '%s', '%s' or '%s'", deleteAfterAlways, deleteAfterOnSuccess, deleteAfterNever), 1)
+	}
+	waitLimit := c.Int("wait_limit")
+	cancelOnInterrupt := c.Bool("cancel_on_interrupt")
+	junitReport := c.String("junit_report")
+
+	ctx, interrupted := interruptibleContext()
+	fileNo, err := client.UploadAppContext(ctx, appPath)
+	if interrupted() {
+		return cli.NewExitError("\ninterrupted", exitCodeInterrupted)
+	}
+	if err != nil {
+		return err
+	}
+	if isTextOutput(c) {
+		fmt.Printf("uploaded app file #%d\n", fileNo)
+	}
+	switch s := runSetting.(type) {
+	case *common.BatchRunSetting:
+		s.WithAppFileNumber(fileNo)
+	case *common.CrossBatchRunSetting:
+		s.WithAppFileNumber(fileNo)
+	}
+
+	startTime := time.Now()
+	batchRun, existsErr, existsUnresolved, batchRunError := client.ExecuteBatchRunWithSettingContext(ctx, runSetting, true, waitLimit, isTextOutput(c))
+	if interrupted() {
+		var afterStop func()
+		if deleteAfter == deleteAfterAlways {
+			// the file is used by the batch run until it stops
+			afterStop = func() {
+				if err := deleteUploadedApp(client, fileNo); err != nil {
+					fmt.Fprintf(os.Stderr, "\nfailed to delete app file #%d: %s", fileNo, err)
+				}
+			}
+		}
+		return stopInterruptedBatchRun(client, batchRun, cancelOnInterrupt, afterStop)
+	}
+	duration := time.Since(startTime)
+	if batchRunError == nil && (junitReport != "" || !isTextOutput(c) || deleteAfter == deleteAfterAlways) {
+		// batchRun is the state when it started, so retrieve the final state
+		var finalBatchRun *common.BatchRun
+		if finalBatchRun, batchRunError = client.GetBatchRunContext(ctx, batchRun.Batch_Run_Number); batchRunError == nil {
+			batchRun = finalBatchRun
+		}
+	}
+	// the batch run may be still using the file if the wait timed out or the final state is unknown
+	mayBeRunning := batchRun != nil && (batchRunError != nil || batchRun.Status == "running")
+	if batchRunError == nil && junitReport != "" {
+		batchRunError = common.SaveJUnitReport(batchRun, junitReport)
+	}
+
+	succeeded := batchRunError == nil && !existsErr && !existsUnresolved
+	deleted := false
+	if deleteAfter == deleteAfterAlways && mayBeRunning {
+		fmt.Fprintf(os.Stderr, "app file #%d is not deleted since batch run #%d may be still running\n", fileNo, batchRun.Batch_Run_Number)
+	} else if deleteAfter == deleteAfterAlways || (deleteAfter == deleteAfterOnSuccess && succeeded) {
+		if err := deleteUploadedApp(client, fileNo); err != nil {
+			if batchRunError == nil {
+				batchRunError = err
+			}
+		} else {
+			deleted = true
+			if isTextOutput(c) {
+				fmt.Printf("deleted app file #%d\n", fileNo)
+			}
+		}
+	}
+	if batchRunError != nil {
+		return batchRunError
+	}
+	if !isTextOutput(c) {
+		output := &runAppOutput{AppFileNumber: fileNo, AppDeleted: deleted, BatchRun: newBatchRunOutput(batchRun)}
+		durationSeconds := int(duration.Seconds())
+		output.BatchRun.DurationSeconds = &durationSeconds
+		if err := printOutput(c, "", output); err != nil {
+			return err
+		}
+	}
+	if existsErr {
+		return cli.NewExitError("", 1)
+	}
+	if existsUnresolved {
+		return cli.NewExitError("", 2)
+	}
+	return nil
+}
+
+// deleteUploadedApp deletes the file with a new context since the original one may be already canceled
+func deleteUploadedApp(client *common.Client, fileNo int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return client.DeleteAppContext(ctx, fileNo)
+}
+
+func rerunFailedAction(c *cli.Context) error {
+	// handle command line arguments
+	client, err := createClient(c)
+	if err != nil {
+		return err
+	}
+	batchRunNumber := c.Int("batch_run_number")
+	if batchRunNumber == 0 {
+		return cli.NewExitError("--batch_run_number option is not specified or 0", 1)
+	}
+	testSettingsNumber := c.Int("test_settings_number")
+	setting, err := settingFromFlags(c)
+	if err != nil {
+		return err
+	}
+	waitLimit := c.Int("wait_limit")
+	mergeResults := c.Bool("merge_results")
+	cancelOnInterrupt := c.Bool("cancel_on_interrupt")
+
+	original, err := client.GetBatchRun(batchRunNumber)
+	if err != nil {
+		return err
+	}
+	if original.Status == "running" {
+		return cli.NewExitError(fmt.Sprintf("batch run #%d has not finished yet", batchRunNumber), 1)
+	}
+	plan, err := common.NewRerunPlan(original, setting)
+	if err != nil {
+		return err
+	}
+	if plan == nil {
+		text := fmt.Sprintf("no failed or unresolved test case in batch run #%d\n", batchRunNumber)
+		if err := printOutput(c, text, newBatchRunOutput(original)); err != nil {
+			return err
+		}
+		return batchRunStatusExitError(original.Status)
+	}
+
+	if testSettingsNumber != 0 && !plan.UseTestSettingsNumber(testSettingsNumber) {
+		fmt.Fprintln(os.Stderr, "warning: --test_settings_number is not used since some test settings have no test case to rerun. "+
+			"The devices of the original batch run are specified instead")
+	}
+	ctx, interrupted := interruptibleContext()
+	rerun, existsErr, existsUnresolved, batchRunError := client.ExecuteBatchRunWithSettingContext(ctx, plan.Setting, true, waitLimit, isTextOutput(c))
+	if interrupted() {
+		return stopInterruptedBatchRun(client, rerun, cancelOnInterrupt, nil)
+	}
+	if batchRunError != nil {
 		return batchRunError
 	}
+	if mergeResults || !isTextOutput(c) {
+		// rerun is the state when it started, so retrieve the final state
+		rerun, err = client.GetBatchRunContext(ctx, rerun.Batch_Run_Number)
+		if err != nil {
+			return err
+		}
+	}
+	if mergeResults {
+		merged := plan.Merge(rerun)
+		testCases := merged.Test_Cases
+		text := fmt.Sprintf("merged result of #%d and #%d: %s (%d succeeded, %d failed, %d aborted, %d unresolved / %d)\n",
+			batchRunNumber, rerun.Batch_Run_Number, merged.Status,
+			testCases.Succeeded, testCases.Failed, testCases.Aborted, testCases.Unresolved, testCases.Total)
+		if err := printOutput(c, text, newBatchRunOutput(merged)); err != nil {
+			return err
+		}
+		return batchRunStatusExitError(merged.Status)
+	}
+	if err := printOutput(c, "", newBatchRunOutput(rerun)); err != nil {
+		return err
+	}
 	if existsErr {
 		return cli.NewExitError("", 1)
 	}
@@ -240,6 +1349,105 @@ func batchRunAction(c *cli.Context) error {
 	return nil
 }
 
+// batchRunStatusExitError returns the error whose exit code corresponds to the status of a finished batch run
+func batchRunStatusExitError(status string) error {
+	switch status {
+	case "succeeded":
+		return nil
+	case "unresolved":
+		return cli.NewExitError("", 2)
+	default:
+		return cli.NewExitError("", 1)
+	}
+}
+
+func exportJUnitAction(c *cli.Context) error {
+	// handle command line arguments
+	client, err := createClient(c)
+	if err != nil {
+		return err
+	}
+	batchRunNumber := c.Int("batch_run_number")
+	if batchRunNumber == 0 {
+		return cli.NewExitError("--batch_run_number option is not specified or 0", 1)
+	}
+	junitReport := c.String("junit_report")
+	if junitReport == "" {
+		return cli.NewExitError("--junit_report option cannot be empty", 1)
+	}
+
+	batchRun, err := client.GetBatchRun(batchRunNumber)
+	if err != nil {
+		return err
+	}
+	if batchRun.Status == "running" {
+		return cli.NewExitError(fmt.Sprintf("batch run #%d has not finished yet", batchRunNumber), 1)
+	}
+	if err := common.SaveJUnitReport(batchRun, junitReport); err != nil {
+		return err
+	}
+	return printOutput(c, "", &junitReportOutput{BatchRunNumber: batchRunNumber, ReportPath: junitReport})
+}
+
+// screenshotTypeFlags are options passed to the screenshots API
+func screenshotTypeFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "file_index_type, i",
+			Usage: "'line_number' or 'auto_increment'. If empty string is specified, the type will be 'line_number'",
+		},
+		cli.StringFlag{
+			Name:  "file_name_body_type, B",
+			Usage: "'none' or 'screenshot_name'. If empty string is specified, the type will be 'none'",
+		},
+		cli.StringFlag{
+			Name:  "download_type, D",
+			Usage: "'all' or 'command_only' (i.e. screenshots only for 'Take screenshot' command). If empty string is specified, the type will be 'all'",
+		},
+		cli.BoolFlag{
+			Name:  "mask_dynamically_changed_area, m",
+			Usage: "Mask dynamically changed areas which can cause unexpected image difference between each test",
+		},
+	}
+}
+
+// baselineFlags are options of screenshots subcommands. The file index type and the file name body type are fixed
+// so that screenshots are matched by line number and screenshot name
+func baselineFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "baseline_dir",
+			Value: "screenshot-baseline",
+			Usage: "Directory which has approved screenshots in versions like v1, v2, ...",
+		},
+		cli.StringFlag{
+			Name:  "download_type, D",
+			Value: "all",
+			Usage: "'all' or 'command_only' (i.e. screenshots only for 'Take screenshot' command)",
+		},
+		cli.BoolFlag{
+			Name:  "mask_dynamically_changed_area, m",
+			Usage: "Mask dynamically changed areas which can cause unexpected image difference between each test",
+		},
+	}
+}
+
+func parseScreenshotTypeFlags(c *cli.Context) (string, string, string, bool) {
+	fileIndexType := c.String("file_index_type")
+	if fileIndexType == "" {
+		fileIndexType = "line_number"
+	}
+	fileNameBodyType := c.String("file_name_body_type")
+	if fileNameBodyType == "" {
+		fileNameBodyType = "none"
+	}
+	downloadType := c.String("download_type")
+	if downloadType == "" {
+		downloadType = "all"
+	}
+	return fileIndexType, fileNameBodyType, downloadType, c.Bool("mask_dynamically_changed_area")
+}
+
 func commonFlags() []cli.Flag {
 	return []cli.Flag{
 		cli.StringFlag{
@@ -261,16 +1469,61 @@ func commonFlags() []cli.Flag {
 			Name:  "http_headers, H",
 			Usage: "Additional HTTP headers in JSON string format",
 		},
+		cli.IntFlag{
+			Name:  "max_attempts",
+			Usage: "Max number of attempts of a request failed by a network error or a temporary server error (408, 429, 502, 503, 504). 1 disables retry",
+			Value: defaultRetryPolicy.MaxAttempts,
+		},
+		cli.IntFlag{
+			Name:  "retry_wait",
+			Usage: "Wait in seconds before the first retry. It is doubled for each following retry unless the server specifies Retry-After",
+			Value: int(defaultRetryPolicy.InitialBackoff / time.Second),
+		},
+		cli.IntFlag{
+			Name:  "retry_max_wait",
+			Usage: "Max wait in seconds between retries",
+			Value: int(defaultRetryPolicy.MaxBackoff / time.Second),
+		},
+		cli.BoolFlag{
+			Name:  "retry_all_requests",
+			Usage: "Retry also requests which are not idempotent (file upload and batch run start). They can cause duplicate files or batch runs",
+		},
+	}
+}
+
+var defaultRetryPolicy = common.DefaultRetryPolicy()
+
+func parseRetryFlags(c *cli.Context) common.RetryPolicy {
+	policy := defaultRetryPolicy
+	policy.MaxAttempts = c.Int("max_attempts")
+	policy.InitialBackoff = time.Duration(c.Int("retry_wait")) * time.Second
+	policy.MaxBackoff = time.Duration(c.Int("retry_max_wait")) * time.Second
+	policy.RetryNonIdempotent = c.Bool("retry_all_requests")
+	policy.OnRetry = func(attempt int, wait time.Duration, cause error) {
+		fmt.Fprintf(os.Stderr, "%s\nretrying in %s (attempt %d/%d)\n", cause, wait.Round(time.Second), attempt+1, policy.MaxAttempts)
 	}
+	return policy
 }
 
+// parseCommonFlags reads common options. Values are decided in the following order:
+// 1. command line options, 2. environment variables, 3. the profile in .magicpod.yaml except token and url_base,
+// 4. the profile in the user config file, 5. default values
 func parseCommonFlags(c *cli.Context) (string, string, string, string, map[string]string, error) {
+	p, err := loadProfile(c)
+	if err != nil {
+		return "", "", "", "", nil, err
+	}
 	urlBase := c.GlobalString("url-base")
-	apiToken := c.String("token")
-	organization := c.String("organization")
-	project := c.String("project")
+	if !c.GlobalIsSet("url-base") && p.URLBase != "" {
+		urlBase = p.URLBase
+	}
+	apiToken := stringOrDefault(c.String("token"), p.Token)
+	organization := stringOrDefault(c.String("organization"), p.Organization)
+	project := stringOrDefault(c.String("project"), p.Project)
 	httpHeadersMap := make(map[string]string)
-	var err error
+	for k, v := range p.HTTPHeaders {
+		httpHeadersMap[k] = v
+	}
 	if urlBase == "" {
 		err = cli.NewExitError("url-base argument cannot be empty", 1)
 	} else if apiToken == "" {
@@ -291,3 +1544,37 @@ func parseCommonFlags(c *cli.Context) (string, string, string, string, map[strin
 	}
 	return urlBase, apiToken, organization, project, httpHeadersMap, err
 }
+
+func stringOrDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func createClient(c *cli.Context) (*common.Client, error) {
+	if err := validateOutputFormat(c); err != nil {
+		return nil, err
+	}
+	urlBase, apiToken, organization, project, httpHeadersMap, err := parseCommonFlags(c)
+	if err != nil {
+		return nil, err
+	}
+	options := []common.ClientOption{
+		common.WithURLBase(urlBase),
+		common.WithHTTPHeaders(httpHeadersMap),
+		common.WithRetryPolicy(parseRetryFlags(c)),
+	}
+	if isTerminal(os.Stderr) {
+		options = append(options, common.WithUploadProgress(printUploadProgress))
+	}
+	// enabled for all commands so that files deleted by delete-app or run-app are not reused by upload-app --dedupe
+	if cachePath := common.DefaultUploadCachePath(); cachePath != "" {
+		options = append(options, common.WithUploadCache(cachePath, printUploadCacheWarning))
+	}
+	return common.NewClient(apiToken, organization, project, options...), nil
+}
+
+func printUploadCacheWarning(err error) {
+	fmt.Fprintf(os.Stderr, "warning: failed to update the upload cache: %s\n", err)
+}
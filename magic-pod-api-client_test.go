@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		period string
+		want   time.Duration
+	}{
+		{period: "", want: 0},
+		{period: "0d", want: 0},
+		{period: "30d", want: 30 * 24 * time.Hour},
+		{period: "2w", want: 14 * 24 * time.Hour},
+		{period: "12h", want: 12 * time.Hour},
+		{period: "1h30m", want: 90 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.period, func(t *testing.T) {
+			got, err := parsePeriod(test.period)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParsePeriodError(t *testing.T) {
+	for _, period := range []string{"d", "-1d", "1.5w", "30", "-2h", "week", "1y"} {
+		t.Run(period, func(t *testing.T) {
+			if got, err := parsePeriod(period); err == nil {
+				t.Errorf("got %s, want an error", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Magic-Pod/magic-pod-api-client/common"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+type testCasesOutput struct {
+	Succeeded  int `json:"succeeded" yaml:"succeeded"`
+	Failed     int `json:"failed" yaml:"failed"`
+	Aborted    int `json:"aborted" yaml:"aborted"`
+	Unresolved int `json:"unresolved" yaml:"unresolved"`
+	Total      int `json:"total" yaml:"total"`
+}
+
+type testCaseResultOutput struct {
+	Number          int    `json:"number" yaml:"number"`
+	Name            string `json:"name" yaml:"name"`
+	Status          string `json:"status" yaml:"status"`
+	DurationSeconds *int   `json:"duration_seconds,omitempty" yaml:"duration_seconds,omitempty"`
+	URL             string `json:"url" yaml:"url"`
+}
+
+type batchRunDetailOutput struct {
+	PatternName string                 `json:"pattern_name" yaml:"pattern_name"`
+	Device      string                 `json:"device,omitempty" yaml:"device,omitempty"`
+	TestCases   []testCaseResultOutput `json:"test_cases" yaml:"test_cases"`
+}
+
+type batchRunOutput struct {
+	BatchRunNumber  int                    `json:"batch_run_number" yaml:"batch_run_number"`
+	URL             string                 `json:"url" yaml:"url"`
+	Status          string                 `json:"status" yaml:"status"`
+	TestCases       testCasesOutput        `json:"test_cases" yaml:"test_cases"`
+	DurationSeconds *int                   `json:"duration_seconds,omitempty" yaml:"duration_seconds,omitempty"`
+	Details         []batchRunDetailOutput `json:"details,omitempty" yaml:"details,omitempty"`
+}
+
+type runAppOutput struct {
+	AppFileNumber int             `json:"app_file_number" yaml:"app_file_number"`
+	AppDeleted    bool            `json:"app_deleted" yaml:"app_deleted"`
+	BatchRun      *batchRunOutput `json:"batch_run" yaml:"batch_run"`
+}
+
+type batchRunNumberOutput struct {
+	BatchRunNumber int `json:"batch_run_number" yaml:"batch_run_number"`
+}
+
+type appFileOutput struct {
+	AppFileNumber int    `json:"app_file_number" yaml:"app_file_number"`
+	AppPath       string `json:"app_path,omitempty" yaml:"app_path,omitempty"`
+	Reused        bool   `json:"reused,omitempty" yaml:"reused,omitempty"`
+	Deleted       bool   `json:"deleted,omitempty" yaml:"deleted,omitempty"`
+}
+
+type uploadedAppsOutput struct {
+	AppFiles []appFileOutput `json:"app_files" yaml:"app_files"`
+}
+
+type uploadedAppFileOutput struct {
+	AppFileNumber int    `json:"app_file_number" yaml:"app_file_number"`
+	FileName      string `json:"file_name" yaml:"file_name"`
+	Size          int64  `json:"size" yaml:"size"`
+	UploadedAt    string `json:"uploaded_at" yaml:"uploaded_at"`
+	UploadedBy    string `json:"uploaded_by" yaml:"uploaded_by"`
+}
+
+type appFilesOutput struct {
+	AppFiles []uploadedAppFileOutput `json:"app_files" yaml:"app_files"`
+}
+
+type pruneAppsOutput struct {
+	DryRun   bool                    `json:"dry_run" yaml:"dry_run"`
+	AppFiles []uploadedAppFileOutput `json:"app_files" yaml:"app_files"`
+}
+
+type screenshotsOutput struct {
+	BatchRunNumber int    `json:"batch_run_number" yaml:"batch_run_number"`
+	DownloadPath   string `json:"download_path" yaml:"download_path"`
+	ExtractedTo    string `json:"extracted_to,omitempty" yaml:"extracted_to,omitempty"`
+	ManifestPath   string `json:"manifest_path,omitempty" yaml:"manifest_path,omitempty"`
+	Screenshots    int    `json:"screenshots,omitempty" yaml:"screenshots,omitempty"`
+}
+
+type compareOutput struct {
+	BaselineVersion      string                 `json:"baseline_version,omitempty" yaml:"baseline_version,omitempty"`
+	BaseBatchRunNumber   int                    `json:"base_batch_run_number" yaml:"base_batch_run_number"`
+	TargetBatchRunNumber int                    `json:"target_batch_run_number" yaml:"target_batch_run_number"`
+	Compared             int                    `json:"compared" yaml:"compared"`
+	Failed               int                    `json:"failed" yaml:"failed"`
+	Screenshots          []screenshotDiffOutput `json:"screenshots" yaml:"screenshots"`
+}
+
+type screenshotDiffOutput struct {
+	Key            string  `json:"key" yaml:"key"`
+	Status         string  `json:"status" yaml:"status"`
+	BasePath       string  `json:"base_path,omitempty" yaml:"base_path,omitempty"`
+	TargetPath     string  `json:"target_path,omitempty" yaml:"target_path,omitempty"`
+	DiffPercentage float64 `json:"diff_percentage" yaml:"diff_percentage"`
+	DiffPath       string  `json:"diff_path,omitempty" yaml:"diff_path,omitempty"`
+}
+
+type junitReportOutput struct {
+	BatchRunNumber int    `json:"batch_run_number" yaml:"batch_run_number"`
+	ReportPath     string `json:"report_path" yaml:"report_path"`
+}
+
+type baselineOutput struct {
+	BatchRunNumber int    `json:"batch_run_number" yaml:"batch_run_number"`
+	Version        string `json:"version" yaml:"version"`
+	Path           string `json:"path" yaml:"path"`
+	Screenshots    int    `json:"screenshots" yaml:"screenshots"`
+}
+
+type htmlReportOutput struct {
+	BatchRunNumber int    `json:"batch_run_number" yaml:"batch_run_number"`
+	ReportPath     string `json:"report_path" yaml:"report_path"`
+	Screenshots    int    `json:"screenshots" yaml:"screenshots"`
+}
+
+type settingOutput struct {
+	Valid   bool            `json:"valid" yaml:"valid"`
+	Setting json.RawMessage `json:"setting" yaml:"-"`
+	// YAMLSetting is the same as Setting since yaml cannot encode json.RawMessage. JSON keeps the order of the keys by Setting
+	YAMLSetting interface{} `json:"-" yaml:"setting"`
+}
+
+func newSettingOutput(setting string) (*settingOutput, error) {
+	decoder := json.NewDecoder(strings.NewReader(setting))
+	decoder.UseNumber()
+	var yamlSetting interface{}
+	if err := decoder.Decode(&yamlSetting); err != nil {
+		return nil, err
+	}
+	return &settingOutput{Valid: true, Setting: json.RawMessage(setting), YAMLSetting: yamlSetting}, nil
+}
+
+func newBatchRunOutput(batchRun *common.BatchRun) *batchRunOutput {
+	output := &batchRunOutput{
+		BatchRunNumber: batchRun.Batch_Run_Number,
+		URL:            batchRun.Url,
+		Status:         batchRun.Status,
+		TestCases: testCasesOutput{
+			Succeeded:  batchRun.Test_Cases.Succeeded,
+			Failed:     batchRun.Test_Cases.Failed,
+			Aborted:    batchRun.Test_Cases.Aborted,
+			Unresolved: batchRun.Test_Cases.Unresolved,
+			Total:      batchRun.Test_Cases.Total,
+		},
+	}
+	for _, detail := range batchRun.Test_Cases.Details {
+		detailOutput := batchRunDetailOutput{
+			PatternName: detail.Pattern_Name,
+			Device:      detail.Device(),
+			TestCases:   []testCaseResultOutput{},
+		}
+		for _, result := range detail.Included_Test_Cases {
+			resultOutput := testCaseResultOutput{
+				Number: result.Number,
+				Name:   result.Name,
+				Status: result.Status,
+				URL:    result.Url,
+			}
+			if duration, ok := result.Duration(); ok {
+				durationSeconds := int(duration.Seconds())
+				resultOutput.DurationSeconds = &durationSeconds
+			}
+			detailOutput.TestCases = append(detailOutput.TestCases, resultOutput)
+		}
+		output.Details = append(output.Details, detailOutput)
+	}
+	return output
+}
+
+// batchRunText formats the summary and the result of each test case as a table
+func batchRunText(batchRun *common.BatchRun) string {
+	var buf bytes.Buffer
+	testCases := batchRun.Test_Cases
+	fmt.Fprintf(&buf, "batch run #%d %s (%d succeeded, %d failed, %d aborted, %d unresolved / %d)\n",
+		batchRun.Batch_Run_Number, batchRun.Status, testCases.Succeeded, testCases.Failed, testCases.Aborted, testCases.Unresolved, testCases.Total)
+	fmt.Fprintf(&buf, "%s\n", batchRun.Url)
+	if len(testCases.Details) == 0 {
+		return buf.String()
+	}
+	fmt.Fprintln(&buf)
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tDEVICE\tNO\tNAME\tSTATUS\tDURATION\tRESULT")
+	for _, detail := range testCases.Details {
+		for _, result := range detail.Included_Test_Cases {
+			duration := "-"
+			if d, ok := result.Duration(); ok {
+				duration = d.String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+				detail.Pattern_Name, detail.Device(), result.Number, result.Name, result.Status, duration, result.Url)
+		}
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func newAppFilesOutput(appFiles []common.AppFile) *appFilesOutput {
+	output := &appFilesOutput{AppFiles: []uploadedAppFileOutput{}}
+	for _, appFile := range appFiles {
+		output.AppFiles = append(output.AppFiles, uploadedAppFileOutput{
+			AppFileNumber: appFile.File_No,
+			FileName:      appFile.File_Name,
+			Size:          appFile.Size,
+			UploadedAt:    appFile.Uploaded_At,
+			UploadedBy:    appFile.Uploaded_By,
+		})
+	}
+	return output
+}
+
+// appFilesText formats uploaded files as a table
+func appFilesText(appFiles []common.AppFile) string {
+	if len(appFiles) == 0 {
+		return "no app file is uploaded in this project\n"
+	}
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NO\tNAME\tSIZE\tUPLOADED AT\tUPLOADED BY")
+	for _, appFile := range appFiles {
+		uploadedAt := appFile.Uploaded_At
+		if t, ok := appFile.UploadedAt(); ok {
+			uploadedAt = t.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+			appFile.File_No, appFile.File_Name, formatBytes(float64(appFile.Size)), uploadedAt, appFile.Uploaded_By)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func newCompareOutput(baseNumber int, targetNumber int, diffs []common.ScreenshotDiff) *compareOutput {
+	output := &compareOutput{
+		BaseBatchRunNumber:   baseNumber,
+		TargetBatchRunNumber: targetNumber,
+		Compared:             len(diffs),
+		Screenshots:          []screenshotDiffOutput{},
+	}
+	for _, diff := range diffs {
+		if diff.Failed() {
+			output.Failed++
+		}
+		diffOutput := screenshotDiffOutput{
+			Key:            diff.Key,
+			Status:         diff.Status,
+			DiffPercentage: diff.Diff_Percentage,
+			DiffPath:       diff.Diff_Path,
+		}
+		if diff.Base != nil {
+			diffOutput.BasePath = diff.Base.Path
+		}
+		if diff.Target != nil {
+			diffOutput.TargetPath = diff.Target.Path
+		}
+		output.Screenshots = append(output.Screenshots, diffOutput)
+	}
+	return output
+}
+
+// compareText formats screenshots which differ as a table
+func compareText(output *compareOutput) string {
+	var buf bytes.Buffer
+	if output.BaselineVersion != "" {
+		fmt.Fprintf(&buf, "%d of %d screenshots differ between baseline %s (batch run #%d) and batch run #%d\n",
+			output.Failed, output.Compared, output.BaselineVersion, output.BaseBatchRunNumber, output.TargetBatchRunNumber)
+	} else {
+		fmt.Fprintf(&buf, "%d of %d screenshots differ between batch run #%d and #%d\n",
+			output.Failed, output.Compared, output.BaseBatchRunNumber, output.TargetBatchRunNumber)
+	}
+	if output.Failed == 0 {
+		return buf.String()
+	}
+	fmt.Fprintln(&buf)
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tDIFF\tSCREENSHOT\tDIFF IMAGE")
+	for _, diff := range output.Screenshots {
+		if diff.Status == common.ScreenshotSame {
+			continue
+		}
+		path := diff.TargetPath
+		if path == "" {
+			path = diff.BasePath
+		}
+		percentage := "-"
+		if diff.Status == common.ScreenshotDifferent {
+			percentage = fmt.Sprintf("%.2f%%", diff.DiffPercentage)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", diff.Status, percentage, path, diff.DiffPath)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func outputFormat(c *cli.Context) string {
+	return c.GlobalString("output")
+}
+
+func isTextOutput(c *cli.Context) bool {
+	return outputFormat(c) == outputText
+}
+
+func validateOutputFormat(c *cli.Context) error {
+	switch outputFormat(c) {
+	case outputText, outputJSON, outputYAML:
+		return nil
+	default:
+		return cli.NewExitError(fmt.Sprintf("--output must be '%s', '%s' or '%s'", outputText, outputJSON, outputYAML), 1)
+	}
+}
+
+// printOutput prints text in text mode, or document encoded in JSON or YAML otherwise
+func printOutput(c *cli.Context, text string, document interface{}) error {
+	switch outputFormat(c) {
+	case outputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(document)
+	case outputYAML:
+		bytes, err := yaml.Marshal(document)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(bytes))
+	default:
+		fmt.Print(text)
+	}
+	return nil
+}
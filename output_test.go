@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestNewSettingOutput(t *testing.T) {
+	output, err := newSettingOutput(`{"model":"iPhone 8","app_file_number":3,"test_settings":[{"version":"14.4"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotYAML, err := yaml.Marshal(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantYAML := `valid: true
+setting:
+  app_file_number: 3
+  model: iPhone 8
+  test_settings:
+  - version: "14.4"
+`
+	if string(gotYAML) != wantYAML {
+		t.Errorf("YAML is\n%s\nwant\n%s", gotYAML, wantYAML)
+	}
+	// JSON keeps the order of the keys
+	gotJSON, err := json.Marshal(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON := `{"valid":true,"setting":{"model":"iPhone 8","app_file_number":3,"test_settings":[{"version":"14.4"}]}}`
+	if string(gotJSON) != wantJSON {
+		t.Errorf("JSON is %s, want %s", gotJSON, wantJSON)
+	}
+}
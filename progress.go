@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Magic-Pod/magic-pod-api-client/common"
+)
+
+// isTerminal reports whether the file is a terminal, not redirected to a file or a pipe
+func isTerminal(file *os.File) bool {
+	stat, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+func formatBytes(bytes float64) string {
+	const unit = 1024
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for bytes >= unit && i < len(units)-1 {
+		bytes /= unit
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", bytes, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", bytes, units[i])
+}
+
+// uploadProgresses holds the latest progress of each file uploaded concurrently
+var uploadProgresses = struct {
+	sync.Mutex
+	files map[string]common.UploadProgress
+}{files: make(map[string]common.UploadProgress)}
+
+// printUploadProgress overwrites one line of stderr with the latest progress.
+// Progresses of files uploaded concurrently are summed up into the line
+func printUploadProgress(progress common.UploadProgress) {
+	uploadProgresses.Lock()
+	defer uploadProgresses.Unlock()
+	uploadProgresses.files[progress.FileName] = progress
+	if len(uploadProgresses.files) > 1 {
+		total := common.UploadProgress{FileName: fmt.Sprintf("%d files", len(uploadProgresses.files))}
+		for _, p := range uploadProgresses.files {
+			total.Sent += p.Sent
+			total.Total += p.Total
+			if p.Elapsed > total.Elapsed {
+				total.Elapsed = p.Elapsed
+			}
+		}
+		progress = total
+	}
+
+	eta := "-"
+	if d, ok := progress.ETA(); ok {
+		eta = d.Round(time.Second).String()
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[Kuploading %s: %s / %s (%.0f%%) %s/s ETA %s",
+		progress.FileName, formatBytes(float64(progress.Sent)), formatBytes(float64(progress.Total)),
+		progress.Percentage(), formatBytes(progress.BytesPerSecond()), eta)
+	if progress.Sent >= progress.Total {
+		fmt.Fprintln(os.Stderr)
+		uploadProgresses.files = make(map[string]common.UploadProgress)
+	}
+}